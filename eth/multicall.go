@@ -0,0 +1,127 @@
+package eth
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Deployed at the same address on most chains.
+// See https://github.com/mds1/multicall
+var Multicall3Address = DecodeHex("0xcA11bde05977b3631167028862bE2a173976CA11")
+
+// A single call in a Multicall3 aggregate3 call.
+// When AllowFailure is false, a failed call reverts
+// the entire aggregate3 call.
+type Multicall3Call struct {
+	Target       []byte
+	AllowFailure bool
+	CallData     []byte
+}
+
+type Multicall3Result struct {
+	Success    bool
+	ReturnData []byte
+}
+
+// aggregate3((address,bool,bytes)[])
+var aggregate3Selector = []byte{0x82, 0xad, 0x56, 0xcb}
+
+func word(n uint64) []byte {
+	var w [32]byte
+	binary.BigEndian.PutUint64(w[24:], n)
+	return w[:]
+}
+
+func padded(b []byte) []byte {
+	n := (len(b) + 31) / 32 * 32
+	res := make([]byte, n)
+	copy(res, b)
+	return res
+}
+
+// ABI encodes calls as aggregate3 calldata
+func EncodeAggregate3(calls []Multicall3Call) []byte {
+	var (
+		heads []byte
+		tails []byte
+		hsize = uint64(32 * len(calls))
+	)
+	for _, c := range calls {
+		heads = append(heads, word(hsize+uint64(len(tails)))...)
+		var target [32]byte
+		copy(target[32-min(len(c.Target), 20):], c.Target)
+		tails = append(tails, target[:]...)
+		if c.AllowFailure {
+			tails = append(tails, word(1)...)
+		} else {
+			tails = append(tails, word(0)...)
+		}
+		tails = append(tails, word(96)...)
+		tails = append(tails, word(uint64(len(c.CallData)))...)
+		tails = append(tails, padded(c.CallData)...)
+	}
+	res := append([]byte{}, aggregate3Selector...)
+	res = append(res, word(32)...)
+	res = append(res, word(uint64(len(calls)))...)
+	res = append(res, heads...)
+	return append(res, tails...)
+}
+
+// reads the word at b[i:] as an offset or length
+// that must fit within b
+func readSize(b []byte, i uint64) (uint64, error) {
+	if i > uint64(len(b)) || uint64(len(b))-i < 32 {
+		return 0, fmt.Errorf("word at %d out of range", i)
+	}
+	w := b[i : i+32]
+	for _, x := range w[:24] {
+		if x != 0 {
+			return 0, fmt.Errorf("word at %d overflows", i)
+		}
+	}
+	n := binary.BigEndian.Uint64(w[24:])
+	if n > uint64(len(b)) {
+		return 0, fmt.Errorf("word at %d exceeds input", i)
+	}
+	return n, nil
+}
+
+// Decodes the (bool,bytes)[] returned by aggregate3
+func DecodeAggregate3(b []byte) ([]Multicall3Result, error) {
+	off, err := readSize(b, 0)
+	if err != nil {
+		return nil, fmt.Errorf("decoding results offset: %w", err)
+	}
+	n, err := readSize(b, off)
+	if err != nil {
+		return nil, fmt.Errorf("decoding results length: %w", err)
+	}
+	var (
+		arr = b[off+32:]
+		res = make([]Multicall3Result, n)
+	)
+	for i := uint64(0); i < n; i++ {
+		toff, err := readSize(arr, 32*i)
+		if err != nil {
+			return nil, fmt.Errorf("decoding result %d: %w", i, err)
+		}
+		if _, err := readSize(arr, toff); err != nil {
+			return nil, fmt.Errorf("decoding result %d success: %w", i, err)
+		}
+		res[i].Success = arr[toff+31] == 1
+		doff, err := readSize(arr, toff+32)
+		if err != nil {
+			return nil, fmt.Errorf("decoding result %d data offset: %w", i, err)
+		}
+		dlen, err := readSize(arr, toff+doff)
+		if err != nil {
+			return nil, fmt.Errorf("decoding result %d data length: %w", i, err)
+		}
+		data := arr[toff+doff+32:]
+		if dlen > uint64(len(data)) {
+			return nil, fmt.Errorf("decoding result %d: data exceeds input", i)
+		}
+		res[i].ReturnData = data[:dlen]
+	}
+	return res, nil
+}
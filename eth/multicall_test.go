@@ -0,0 +1,58 @@
+package eth
+
+import (
+	"strings"
+	"testing"
+
+	"kr.dev/diff"
+)
+
+func TestEncodeAggregate3(t *testing.T) {
+	diff.Test(t, t.Errorf, Keccak([]byte("aggregate3((address,bool,bytes)[])"))[:4], aggregate3Selector)
+	got := EncodeAggregate3([]Multicall3Call{
+		{Target: DecodeHex("0x01"), AllowFailure: true, CallData: DecodeHex("0xabcd")},
+		{Target: DecodeHex("0x02")},
+	})
+	want := DecodeHex(strings.Join([]string{
+		"82ad56cb",
+		"0000000000000000000000000000000000000000000000000000000000000020",
+		"0000000000000000000000000000000000000000000000000000000000000002",
+		"0000000000000000000000000000000000000000000000000000000000000040",
+		"00000000000000000000000000000000000000000000000000000000000000e0",
+		"0000000000000000000000000000000000000000000000000000000000000001",
+		"0000000000000000000000000000000000000000000000000000000000000001",
+		"0000000000000000000000000000000000000000000000000000000000000060",
+		"0000000000000000000000000000000000000000000000000000000000000002",
+		"abcd000000000000000000000000000000000000000000000000000000000000",
+		"0000000000000000000000000000000000000000000000000000000000000002",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"0000000000000000000000000000000000000000000000000000000000000060",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+	}, ""))
+	diff.Test(t, t.Errorf, want, got)
+}
+
+func TestDecodeAggregate3(t *testing.T) {
+	input := DecodeHex(strings.Join([]string{
+		"0000000000000000000000000000000000000000000000000000000000000020",
+		"0000000000000000000000000000000000000000000000000000000000000002",
+		"0000000000000000000000000000000000000000000000000000000000000040",
+		"00000000000000000000000000000000000000000000000000000000000000c0",
+		"0000000000000000000000000000000000000000000000000000000000000001",
+		"0000000000000000000000000000000000000000000000000000000000000040",
+		"0000000000000000000000000000000000000000000000000000000000000002",
+		"abcd000000000000000000000000000000000000000000000000000000000000",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"0000000000000000000000000000000000000000000000000000000000000040",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+	}, ""))
+	got, err := DecodeAggregate3(input)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, []Multicall3Result{
+		{Success: true, ReturnData: []byte{0xab, 0xcd}},
+		{Success: false, ReturnData: []byte{}},
+	}, got)
+
+	_, err = DecodeAggregate3(input[:len(input)-32])
+	diff.Test(t, t.Errorf, true, err != nil)
+}
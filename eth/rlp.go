@@ -0,0 +1,49 @@
+package eth
+
+import "encoding/binary"
+
+// RLP encodes b as a string
+func EncodeRLPBytes(b []byte) []byte {
+	if len(b) == 1 && b[0] < 0x80 {
+		return []byte{b[0]}
+	}
+	return append(rlpHeader(0x80, len(b)), b...)
+}
+
+// RLP encodes n as a string without leading zeros
+func EncodeRLPUint(n uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n)
+	i := 0
+	for i < len(b) && b[i] == 0 {
+		i++
+	}
+	return EncodeRLPBytes(b[i:])
+}
+
+// RLP encodes the already encoded items as a list
+func EncodeRLPList(items ...[]byte) []byte {
+	var n int
+	for i := range items {
+		n += len(items[i])
+	}
+	res := rlpHeader(0xc0, n)
+	for i := range items {
+		res = append(res, items[i]...)
+	}
+	return res
+}
+
+// offset is 0x80 for strings and 0xc0 for lists
+func rlpHeader(offset byte, n int) []byte {
+	if n <= 55 {
+		return []byte{offset + byte(n)}
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(n))
+	i := 0
+	for b[i] == 0 {
+		i++
+	}
+	return append([]byte{offset + 55 + byte(8-i)}, b[i:]...)
+}
@@ -0,0 +1,33 @@
+package eth
+
+import (
+	"bytes"
+	"testing"
+
+	"kr.dev/diff"
+)
+
+func TestEncodeRLP(t *testing.T) {
+	long := bytes.Repeat([]byte{'a'}, 56)
+	cases := []struct {
+		got  []byte
+		want []byte
+	}{
+		{EncodeRLPBytes(nil), DecodeHex("0x80")},
+		{EncodeRLPBytes([]byte{0x0f}), DecodeHex("0x0f")},
+		{EncodeRLPBytes([]byte{0x80}), DecodeHex("0x8180")},
+		{EncodeRLPBytes([]byte("dog")), DecodeHex("0x83646f67")},
+		{EncodeRLPBytes(long), append(DecodeHex("0xb838"), long...)},
+		{EncodeRLPUint(0), DecodeHex("0x80")},
+		{EncodeRLPUint(15), DecodeHex("0x0f")},
+		{EncodeRLPUint(1024), DecodeHex("0x820400")},
+		{EncodeRLPList(), DecodeHex("0xc0")},
+		{
+			EncodeRLPList(EncodeRLPBytes([]byte("cat")), EncodeRLPBytes([]byte("dog"))),
+			DecodeHex("0xc88363617483646f67"),
+		},
+	}
+	for _, tc := range cases {
+		diff.Test(t, t.Errorf, tc.want, tc.got)
+	}
+}
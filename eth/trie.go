@@ -0,0 +1,119 @@
+package eth
+
+// Root of a trie without any keys
+var EmptyRoot = Keccak(EncodeRLPBytes(nil))
+
+// Returns the root of the Merkle Patricia trie that maps
+// the RLP encoded index of each value to the value. This is
+// how a header's transactionsRoot and receiptsRoot are
+// derived from the block's encoded transactions and receipts.
+func DeriveRoot(values [][]byte) []byte {
+	kvs := make([]trieKV, len(values))
+	for i := range values {
+		kvs[i] = trieKV{nibbles(EncodeRLPUint(uint64(i))), values[i]}
+	}
+	return trieRoot(kvs)
+}
+
+type trieKV struct {
+	k []byte // one nibble per byte
+	v []byte
+}
+
+func nibbles(b []byte) []byte {
+	res := make([]byte, 2*len(b))
+	for i := range b {
+		res[2*i] = b[i] >> 4
+		res[2*i+1] = b[i] & 0x0f
+	}
+	return res
+}
+
+// Keys must be unique
+func trieRoot(kvs []trieKV) []byte {
+	if len(kvs) == 0 {
+		return EmptyRoot
+	}
+	return Keccak(trieNode(kvs, 0))
+}
+
+// Returns the encoded node for kvs whose keys
+// share the first d nibbles.
+func trieNode(kvs []trieKV, d int) []byte {
+	if len(kvs) == 1 {
+		return EncodeRLPList(
+			EncodeRLPBytes(hexPrefix(kvs[0].k[d:], true)),
+			EncodeRLPBytes(kvs[0].v),
+		)
+	}
+	if n := commonPrefix(kvs, d); n > 0 {
+		return EncodeRLPList(
+			EncodeRLPBytes(hexPrefix(kvs[0].k[d:d+n], false)),
+			trieRef(trieNode(kvs, d+n)),
+		)
+	}
+	var (
+		children [16][]trieKV
+		value    []byte
+	)
+	for i := range kvs {
+		if len(kvs[i].k) == d {
+			value = kvs[i].v
+			continue
+		}
+		n := kvs[i].k[d]
+		children[n] = append(children[n], kvs[i])
+	}
+	items := make([][]byte, 17)
+	for i := range children {
+		if len(children[i]) == 0 {
+			items[i] = EncodeRLPBytes(nil)
+			continue
+		}
+		items[i] = trieRef(trieNode(children[i], d+1))
+	}
+	items[16] = EncodeRLPBytes(value)
+	return EncodeRLPList(items...)
+}
+
+// Number of nibbles after d that all keys share
+func commonPrefix(kvs []trieKV, d int) int {
+	n := len(kvs[0].k) - d
+	for i := 1; i < len(kvs); i++ {
+		k := kvs[i].k[d:]
+		n = min(n, len(k))
+		for j := 0; j < n; j++ {
+			if k[j] != kvs[0].k[d+j] {
+				n = j
+				break
+			}
+		}
+	}
+	return n
+}
+
+// Nodes shorter than a hash are embedded in their parent
+func trieRef(node []byte) []byte {
+	if len(node) < 32 {
+		return node
+	}
+	return EncodeRLPBytes(Keccak(node))
+}
+
+func hexPrefix(nibs []byte, leaf bool) []byte {
+	var flag byte
+	if leaf {
+		flag = 2
+	}
+	var res []byte
+	if len(nibs)%2 == 1 {
+		res = append(res, (flag+1)<<4|nibs[0])
+		nibs = nibs[1:]
+	} else {
+		res = append(res, flag<<4)
+	}
+	for i := 0; i < len(nibs); i += 2 {
+		res = append(res, nibs[i]<<4|nibs[i+1])
+	}
+	return res
+}
@@ -0,0 +1,23 @@
+package eth
+
+import (
+	"testing"
+
+	"kr.dev/diff"
+)
+
+func TestTrieRoot(t *testing.T) {
+	diff.Test(t, t.Errorf,
+		DecodeHex("0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421"),
+		DeriveRoot(nil),
+	)
+	kvs := []trieKV{
+		{nibbles([]byte("doe")), []byte("reindeer")},
+		{nibbles([]byte("dog")), []byte("puppy")},
+		{nibbles([]byte("dogglesworth")), []byte("cat")},
+	}
+	diff.Test(t, t.Errorf,
+		DecodeHex("0x8aad789dff2f538bca5d8ea56e8abe10f4c7ba3a5dea95fea4cd6e7c3a1168d3"),
+		trieRoot(kvs),
+	)
+}
@@ -3,6 +3,7 @@ package eth
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"sync"
 
@@ -130,6 +131,15 @@ func (b Block) String() string {
 	)
 }
 
+// Returns the calldata length of each tx
+func (b *Block) DataLengths() []int {
+	res := make([]int, len(b.Txs))
+	for i := range b.Txs {
+		res[i] = b.Txs[i].DataLength()
+	}
+	return res
+}
+
 func (b *Block) Tx(idx uint64) *Tx {
 	for i := range b.Txs {
 		if uint64(b.Txs[i].Idx) == idx {
@@ -168,11 +178,11 @@ func (ls *Logs) Add(other *Log) {
 }
 
 type Receipt struct {
-	Status            Byte
-	GasUsed           Uint64
-	EffectiveGasPrice uint256.Int
-	Logs              Logs
-	ContractAddress   Bytes
+	Status              Byte
+	GasUsed             Uint64
+	EffectiveGasPrice   uint256.Int
+	Logs                Logs
+	ContractAddress     Bytes
 	L1BaseFeeScalar     *uint256.Int `json:"l1BaseFeeScalar,omitempty"`
 	L1BlobBaseFee       *uint256.Int `json:"l1BlobBaseFee,omitempty"`
 	L1BlobBaseFeeScalar *uint256.Int `json:"l1BlobBaseFeeScalar,omitempty"`
@@ -187,6 +197,50 @@ type Header struct {
 	Parent    Bytes  `json:"parentHash"`
 	LogsBloom Bytes  `json:"logsBloom"`
 	Time      Uint64 `json:"timestamp"`
+
+	TxRoot       Bytes `json:"transactionsRoot"`
+	ReceiptsRoot Bytes `json:"receiptsRoot"`
+
+	// Hashes of the block's uncles (ommers). Always
+	// empty after the merge. See eth_getUncleByBlockNumberAndIndex.
+	Uncles []Bytes `json:"uncles"`
+
+	Size     Uint64 `json:"size"`
+	GasLimit Uint64 `json:"gasLimit"`
+	GasUsed  Uint64 `json:"gasUsed"`
+
+	// Zero before London (EIP-1559)
+	BaseFee uint256.Int `json:"baseFeePerGas"`
+
+	// Post-merge, MixHash is prevRandao and
+	// Difficulty and Nonce are zero.
+	MixHash    Bytes       `json:"mixHash"`
+	Difficulty uint256.Int `json:"difficulty"`
+	Nonce      Bytes       `json:"nonce"`
+}
+
+type StorageProof struct {
+	Key   Bytes       `json:"key"`
+	Value uint256.Int `json:"value"`
+	Proof []Bytes     `json:"proof"`
+}
+
+// Params for eth_call
+type CallMsg struct {
+	From Bytes `json:"from,omitempty"`
+	To   Bytes `json:"to"`
+	Data Bytes `json:"data,omitempty"`
+}
+
+// Result of eth_getProof
+type AccountProof struct {
+	Address      Bytes          `json:"address"`
+	AccountProof []Bytes        `json:"accountProof"`
+	Balance      uint256.Int    `json:"balance"`
+	CodeHash     Bytes          `json:"codeHash"`
+	Nonce        Uint64         `json:"nonce"`
+	StorageHash  Bytes          `json:"storageHash"`
+	StorageProof []StorageProof `json:"storageProof"`
 }
 
 type AccessTuple struct {
@@ -194,8 +248,34 @@ type AccessTuple struct {
 	StorageKeys [][32]byte
 }
 
+func (at *AccessTuple) UnmarshalJSON(data []byte) error {
+	var x struct {
+		Address     Bytes   `json:"address"`
+		StorageKeys []Bytes `json:"storageKeys"`
+	}
+	if err := json.Unmarshal(data, &x); err != nil {
+		return err
+	}
+	copy(at.Address[:], x.Address)
+	at.StorageKeys = make([][32]byte, len(x.StorageKeys))
+	for i := range x.StorageKeys {
+		copy(at.StorageKeys[i][:], x.StorageKeys[i])
+	}
+	return nil
+}
+
 type AccessTuples []AccessTuple
 
+// EIP-7702
+type Authorization struct {
+	ChainID uint256.Int `json:"chainId"`
+	Address Bytes       `json:"address"`
+	Nonce   Uint64      `json:"nonce"`
+	YParity Byte        `json:"yParity"`
+	R       uint256.Int `json:"r"`
+	S       uint256.Int `json:"s"`
+}
+
 type Txs []Tx
 
 type TraceAction struct {
@@ -204,13 +284,20 @@ type TraceAction struct {
 	CallType string      `json:"callType"`
 	To       Bytes       `json:"to"`
 	Value    uint256.Int `json:"value"`
+
+	// From the trace's result and error. Optional
+	// since not every trace method provides them.
+	GasUsed      Uint64 `json:"-"`
+	Output       Bytes  `json:"-"`
+	Error        string `json:"-"`
+	RevertReason string `json:"-"`
 }
 
 type Tx struct {
 	Receipt
 	Idx      Uint64      `json:"transactionIndex"`
 	Type     Byte        `json:"type"`
-	ChainID  uint256.Int `json:"chainID"`
+	ChainID  uint256.Int `json:"chainId"` // zero for pre EIP-155 legacy txs
 	Nonce    Uint64      `json:"nonce"`
 	GasPrice uint256.Int `json:"gasPrice"`
 	GasLimit Uint64      `json:"gas"`
@@ -222,20 +309,50 @@ type Tx struct {
 	R        uint256.Int `json:"r"`
 	S        uint256.Int `json:"s"`
 
+	// Length of Data. Only set when Data
+	// has been discarded. See [Tx.DiscardData].
+	DataLen int `json:"-"`
+
+	// Set for typed transactions. Legacy
+	// transactions encode the parity in V.
+	YParity *Byte `json:"yParity"`
+
 	TraceActions []TraceAction
 
 	// EIP-2930
-	AccessList AccessTuples `json:"-"` // TODO
+	AccessList AccessTuples `json:"accessList"`
 
-	// EIP-1559
+	// EIP-1559. Zero for legacy and access list
+	// transactions which only have GasPrice. For
+	// dynamic fee transactions nodes set GasPrice
+	// to the effective gas price.
 	MaxPriorityFeePerGas uint256.Int `json:"maxPriorityFeePerGas"`
 	MaxFeePerGas         uint256.Int `json:"maxFeePerGas"`
 
+	// EIP-4844
+	MaxFeePerBlobGas    uint256.Int `json:"maxFeePerBlobGas"`
+	BlobVersionedHashes []Bytes     `json:"blobVersionedHashes"`
+
+	// EIP-7702
+	AuthorizationList []Authorization `json:"authorizationList"`
+
 	PrecompHash  Bytes `json:"hash"`
 	cacheMut     sync.Mutex
 	rbuf, signer []byte
 }
 
+// Returns the length of the calldata
+// even if it has been discarded.
+func (tx *Tx) DataLength() int {
+	return max(len(tx.Data), tx.DataLen)
+}
+
+// Drops the calldata and keeps its length
+func (tx *Tx) DiscardData() {
+	tx.DataLen = tx.DataLength()
+	tx.Data = nil
+}
+
 func (tx *Tx) Hash() []byte {
 	tx.cacheMut.Lock()
 	defer tx.cacheMut.Unlock()
@@ -249,6 +366,14 @@ func (tx *Tx) Signer() ([]byte, error) {
 	return tx.From, nil
 }
 
+// Signature recovery id (0 or 1)
+func (tx *Tx) Parity() byte {
+	if tx.YParity != nil {
+		return byte(*tx.YParity)
+	}
+	return tx.v() - 27
+}
+
 func (tx *Tx) v() byte {
 	switch v := tx.V.Uint64(); {
 	case v >= 35:
@@ -1,6 +1,7 @@
 package eth
 
 import (
+	"bytes"
 	"encoding/hex"
 	"encoding/json"
 	"testing"
@@ -123,3 +124,162 @@ func TestBytes_Write(t *testing.T) {
 	diff.Test(t, t.Errorf, 16, len(x))
 	diff.Test(t, t.Errorf, 32, cap(x))
 }
+
+func TestTx_Lists(t *testing.T) {
+	var legacy Tx
+	err := json.Unmarshal([]byte(`{"type": "0x0"}`), &legacy)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, 0, len(legacy.AccessList))
+	diff.Test(t, t.Errorf, 0, len(legacy.AuthorizationList))
+
+	var tx Tx
+	err = json.Unmarshal([]byte(`{
+		"type": "0x4",
+		"accessList": [{
+			"address": "0xde0b295669a9fd93d5f28d9ec85e40f4cb697bae",
+			"storageKeys": ["0x0000000000000000000000000000000000000000000000000000000000000003"]
+		}],
+		"authorizationList": [{
+			"chainId": "0x1",
+			"address": "0x63c0c19a282a1b52b07dd5a65b58948a07dae32b",
+			"nonce": "0x2a",
+			"yParity": "0x1",
+			"r": "0x2",
+			"s": "0x3"
+		}]
+	}`), &tx)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Fatalf, 1, len(tx.AccessList))
+	diff.Test(t, t.Errorf, h2b("de0b295669a9fd93d5f28d9ec85e40f4cb697bae"), tx.AccessList[0].Address[:])
+	diff.Test(t, t.Errorf, byte(3), tx.AccessList[0].StorageKeys[0][31])
+	diff.Test(t, t.Fatalf, 1, len(tx.AuthorizationList))
+	diff.Test(t, t.Errorf, uint64(1), tx.AuthorizationList[0].ChainID.Uint64())
+	diff.Test(t, t.Errorf, Uint64(42), tx.AuthorizationList[0].Nonce)
+	diff.Test(t, t.Errorf, Byte(1), tx.AuthorizationList[0].YParity)
+}
+
+func TestHeader_Uncles(t *testing.T) {
+	var h Header
+	err := json.Unmarshal([]byte(`{"number": "0x1", "uncles": ["0x0a", "0x0b"]}`), &h)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, []Bytes{{0x0a}, {0x0b}}, h.Uncles)
+}
+
+func TestTx_ChainID(t *testing.T) {
+	var legacy Tx
+	err := json.Unmarshal([]byte(`{"type": "0x0", "v": "0x1b"}`), &legacy)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, true, legacy.ChainID.IsZero())
+
+	var tx Tx
+	err = json.Unmarshal([]byte(`{"type": "0x2", "chainId": "0xa"}`), &tx)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, uint64(10), tx.ChainID.Uint64())
+
+	// Decoding matches keys case-insensitively
+	// so the encoded key checks the tag
+	b, err := json.Marshal(&tx)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, true, bytes.Contains(b, []byte(`"chainId":`)))
+}
+
+func TestTx_Blob(t *testing.T) {
+	var legacy Tx
+	err := json.Unmarshal([]byte(`{"type": "0x2"}`), &legacy)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, true, legacy.MaxFeePerBlobGas.IsZero())
+	diff.Test(t, t.Errorf, 0, len(legacy.BlobVersionedHashes))
+
+	var tx Tx
+	err = json.Unmarshal([]byte(`{
+		"type": "0x3",
+		"maxFeePerBlobGas": "0x3b9aca00",
+		"blobVersionedHashes": [
+			"0x01b0a4cdd5f55589f5c5b4d46c76704bb6ce95c0a8c09f77f197a57808dded28",
+			"0x0143e9fc1cf0e57a4c4b5e7bd5a1a3c5a1c6f3f4e5d6c7b8a9f0e1d2c3b4a5f6"
+		]
+	}`), &tx)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, uint64(1e9), tx.MaxFeePerBlobGas.Uint64())
+	diff.Test(t, t.Fatalf, 2, len(tx.BlobVersionedHashes))
+	diff.Test(t, t.Errorf, byte(0x01), tx.BlobVersionedHashes[1][0])
+}
+
+func TestTx_Fees(t *testing.T) {
+	var legacy Tx
+	err := json.Unmarshal([]byte(`{"type": "0x0", "gasPrice": "0x3b9aca00"}`), &legacy)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, uint64(1e9), legacy.GasPrice.Uint64())
+	diff.Test(t, t.Errorf, true, legacy.MaxFeePerGas.IsZero())
+	diff.Test(t, t.Errorf, true, legacy.MaxPriorityFeePerGas.IsZero())
+
+	var tx Tx
+	err = json.Unmarshal([]byte(`{
+		"type": "0x2",
+		"gasPrice": "0x2",
+		"maxFeePerGas": "0x3",
+		"maxPriorityFeePerGas": "0x1"
+	}`), &tx)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, uint64(2), tx.GasPrice.Uint64())
+	diff.Test(t, t.Errorf, uint64(3), tx.MaxFeePerGas.Uint64())
+	diff.Test(t, t.Errorf, uint64(1), tx.MaxPriorityFeePerGas.Uint64())
+}
+
+func TestTx_DiscardData(t *testing.T) {
+	tx := Tx{Data: Bytes{0xa9, 0x05, 0x9c, 0xbb}}
+	diff.Test(t, t.Errorf, 4, tx.DataLength())
+	tx.DiscardData()
+	diff.Test(t, t.Errorf, 0, len(tx.Data))
+	diff.Test(t, t.Errorf, 4, tx.DataLength())
+	tx.DiscardData()
+	diff.Test(t, t.Errorf, 4, tx.DataLength())
+}
+
+func TestTx_Parity(t *testing.T) {
+	cases := []struct {
+		js   string
+		want byte
+	}{
+		{`{"type": "0x0", "v": "0x1b"}`, 0},
+		{`{"type": "0x0", "v": "0x26"}`, 1},
+		{`{"type": "0x2", "v": "0x1", "yParity": "0x1"}`, 1},
+		{`{"type": "0x2", "v": "0x0", "yParity": "0x0"}`, 0},
+	}
+	for _, c := range cases {
+		var tx Tx
+		diff.Test(t, t.Fatalf, nil, json.Unmarshal([]byte(c.js), &tx))
+		diff.Test(t, t.Errorf, c.want, tx.Parity())
+	}
+	var tx Tx
+	err := json.Unmarshal([]byte(`{
+		"type": "0x2",
+		"yParity": "0x1",
+		"r": "0x2",
+		"s": "0x3"
+	}`), &tx)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, uint64(2), tx.R.Uint64())
+	diff.Test(t, t.Errorf, uint64(3), tx.S.Uint64())
+}
+
+func TestHeader_Merge(t *testing.T) {
+	var pre Header
+	err := json.Unmarshal([]byte(`{
+		"number": "0xf4241",
+		"mixHash": "0xd5332614a151dd917b84fc5ff62580d7099edb7c37e0ac843d873de978d50352",
+		"difficulty": "0xb6b4bbd735f",
+		"nonce": "0x0c2e7f2e9cba5e1b"
+	}`), &pre)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, uint64(0xb6b4bbd735f), pre.Difficulty.Uint64())
+	diff.Test(t, t.Errorf, h2b("0c2e7f2e9cba5e1b"), []byte(pre.Nonce))
+	diff.Test(t, t.Errorf, byte(0xd5), pre.MixHash[0])
+
+	var post Header
+	err = json.Unmarshal([]byte(`{"number": "0x112a880"}`), &post)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, true, post.Difficulty.IsZero())
+	diff.Test(t, t.Errorf, 0, len(post.MixHash))
+	diff.Test(t, t.Errorf, 0, len(post.Nonce))
+}
@@ -14,6 +14,7 @@ require (
 	github.com/kr/session v0.2.1
 	golang.org/x/crypto v0.24.0
 	golang.org/x/sync v0.7.0
+	golang.org/x/time v0.5.0
 	kr.dev/diff v0.3.0
 	nhooyr.io/websocket v1.8.10
 )
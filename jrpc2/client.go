@@ -2,9 +2,13 @@
 package jrpc2
 
 import (
+	"bufio"
 	"bytes"
+	"cmp"
 	"context"
 	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -13,6 +17,8 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"reflect"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -29,6 +35,9 @@ import (
 	"github.com/goccy/go-json"
 	"github.com/klauspost/compress/gzhttp"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
 	"nhooyr.io/websocket"
 	"nhooyr.io/websocket/wsjson"
 )
@@ -38,7 +47,17 @@ type URL struct {
 	provided string
 }
 
+// Accepts http(s) urls as well as unix sockets in the
+// form of unix:///path/to/socket or /path/to/socket.
+// Unix sockets must be served by an HTTP server;
+// raw JSON-RPC IPC (such as geth.ipc) isn't supported.
 func MustURL(provided string) *URL {
+	if path, ok := strings.CutPrefix(provided, "unix://"); ok || strings.HasPrefix(provided, "/") {
+		if !ok {
+			path = provided
+		}
+		return &URL{parsed: socketURL(path), provided: provided}
+	}
 	parsed, err := url.Parse(provided)
 	if err != nil {
 		fmt.Printf("unable to parse url: %s\n", provided)
@@ -47,8 +66,74 @@ func MustURL(provided string) *URL {
 	return &URL{parsed: parsed, provided: provided}
 }
 
+// The socket path is hex encoded into the url's host
+// so that the transport's dialer can recover it.
+const socketSuffix = ".unix"
+
+func socketURL(path string) *url.URL {
+	return &url.URL{
+		Scheme: "http",
+		Host:   hex.EncodeToString([]byte(path)) + socketSuffix,
+		Path:   "/",
+	}
+}
+
+func socketPath(host string) (string, bool) {
+	h, ok := strings.CutSuffix(host, socketSuffix)
+	if !ok {
+		return "", false
+	}
+	b, err := hex.DecodeString(h)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
+// Clones http.DefaultTransport unless it has been replaced
+// (e.g. by instrumentation) with a different type, in which
+// case a transport with the same defaults is returned.
+func defaultTransport() *http.Transport {
+	if tr, ok := http.DefaultTransport.(*http.Transport); ok {
+		return tr.Clone()
+	}
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+func dialSockets(dial func(context.Context, string, string) (net.Conn, error)) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dial(ctx, network, addr)
+		}
+		if path, ok := socketPath(host); ok {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
 func (u *URL) Hostname() string {
-	return u.parsed.Hostname()
+	return hostname(u.String())
+}
+
+func (u *URL) redacted() string {
+	if path, ok := socketPath(u.parsed.Host); ok {
+		return "unix://" + path
+	}
+	return (&url.URL{Scheme: u.parsed.Scheme, Host: u.parsed.Host}).String()
 }
 
 func (u *URL) String() string {
@@ -63,54 +148,176 @@ func randbytes() []byte {
 
 func New(providedURLs ...string) *Client {
 	var (
-		urls           []*URL
-		debug, nocache bool
+		urls    []*URL
+		nocache bool
+		debug   bool
 	)
 	for _, provided := range providedURLs {
-		debug = strings.Contains(provided, "debug")
-		nocache = strings.Contains(provided, "nocache")
+		// Deprecated: use WithNoCache and WithDebug.
+		// Honored for one more release.
+		if strings.Contains(provided, "nocache") {
+			slog.Warn("nocache in the url is deprecated. use WithNoCache",
+				"host", hostname(provided),
+			)
+			nocache = true
+		}
+		if strings.Contains(provided, "debug") {
+			slog.Warn("debug in the url is deprecated. use WithDebug",
+				"host", hostname(provided),
+			)
+			debug = true
+		}
 		urls = append(urls, MustURL(provided))
 	}
-	return &Client{
-		d:       debug,
+	tr := defaultTransport()
+	tr.DialContext = dialSockets(tr.DialContext)
+	c := &Client{
+		dmax:    1024,
+		version: "2.0",
 		nocache: nocache,
+		tr:      tr,
 		hc: &http.Client{
 			Timeout:   10 * time.Second,
-			Transport: gzhttp.Transport(http.DefaultTransport),
+			Transport: gzhttp.Transport(tr),
 		},
 		urls:         urls,
 		pollDuration: time.Second,
+		wsPing:       15 * time.Second,
+		clock:        realClock{},
+		archiveDepth: 128,
 		lcache:       NumHash{maxreads: 20},
-		bcache:       cache{maxreads: 20},
-		hcache:       cache{maxreads: 20},
+		cache:        cache{maxreads: 20},
 	}
+	c.d.Store(debug)
+	return c
 }
 
+// A Client is safe for concurrent use by multiple goroutines
+// once it has been configured. The With* methods aren't
+// synchronized and must be called before the client is
+// shared. SetDebug is the exception and may be called at
+// any time.
 type Client struct {
-	nocache bool
-	d       bool
-	hc      *http.Client
-	urls    []*URL
-	wsurl   string
+	name     string
+	nocache  bool
+	d        atomic.Bool
+	dw       io.Writer
+	dmax     int
+	version  string
+	tr       *http.Transport
+	hc       *http.Client
+	customHC bool
+	urls     []*URL
+	wsurls   []string
+	wsPing   time.Duration
+
+	sem           *semaphore.Weighted
+	limits        map[string]*rate.Limiter
+	methodSupport map[string][]string
+	reqCounter    uint64
+	pollDuration  time.Duration
+	clock         Clock
+	pollMin       time.Duration
+	pollMax       time.Duration
+	confirmations uint64
+	partialTraces bool
+	traceMethod   string
+	latestSource  string
+	logsAddrGroup int
+	streamReqs    bool
+	streamf       func(*eth.Block)
 
-	reqCounter   uint64
-	pollDuration time.Duration
+	requireReceiptFields bool
+	strictReceipts       bool
+	combineBatches       bool
+	strictJSON           bool
+	maxLimit             uint64
+	discardCalldata      bool
+	skipLogs             bool
+	skipLogsHook         func(start, limit uint64, err error)
+	skipLinkage          bool
+	verifyRoots          bool
+	allowGaps            bool
+	headerHook           func(string, http.Header)
+	rawCapture           func(method string, raw []byte)
+	multicallAddr        []byte
+	idFunc               func(method string) string
+	cost                 func(method string, n int) int
 
-	lcache NumHash
-	bcache cache
-	hcache cache
+	archiveURLs    []*URL
+	archiveDepth   uint64
+	archiveCounter uint64
+
+	lcache      NumHash
+	negcache    negcache
+	batch       batchSizers
+	store       BlockStore
+	final       finality
+	latestGroup singleflight.Group
+	cache       cache
+
+	methods sync.Map
+
+	fpMut sync.Mutex
+	fps   map[string]fingerprint
+}
+
+// Returns the configured urls in rotation order.
+// Only the scheme and host are included since
+// providers often put credentials in the userinfo,
+// path, or query.
+func (c *Client) URLs() []string {
+	res := make([]string, len(c.urls))
+	for i := range c.urls {
+		res[i] = c.urls[i].redacted()
+	}
+	return res
+}
+
+// Returns the url (see [Client.URLs]) that the next
+// call to NextURL will return without advancing the rotation.
+func (c *Client) PeekURL() string {
+	if len(c.urls) == 0 {
+		return ""
+	}
+	next := (atomic.LoadUint64(&c.reqCounter) + 1) % uint64(len(c.urls))
+	return c.urls[next].redacted()
 }
 
+// When the client has no urls, the returned URL is empty
+// and requests made with it fail with ErrNoURLs.
 func (c *Client) NextURL() *URL {
-	atomic.AddUint64(&c.reqCounter, 1)
-	next := c.reqCounter % uint64(len(c.urls))
+	if len(c.urls) == 0 {
+		return &URL{parsed: &url.URL{}}
+	}
+	next := atomic.AddUint64(&c.reqCounter, 1) % uint64(len(c.urls))
 	return c.urls[next]
 }
 
 func (c *Client) WithMaxReads(n int) *Client {
 	c.lcache.maxreads = n
-	c.bcache.maxreads = n
-	c.hcache.maxreads = n
+	c.cache.maxreads = n
+	return c
+}
+
+// When non-zero, a latest block that hasn't been
+// confirmed by the poller (or websocket) within d is
+// treated as a cache miss and requested from the node.
+// This guards against a stalled poller serving a stale
+// tip. Since websockets only report new blocks, d should
+// be longer than the chain's block time.
+func (c *Client) WithLatestMaxAge(d time.Duration) *Client {
+	c.lcache.maxAge = d
+	return c
+}
+
+// Sets how often websocket connections are pinged.
+// A ping that isn't answered within d closes the
+// connection so that a silently dropped connection
+// is detected and reconnected. Zero disables pings.
+// Defaults to 15s.
+func (c *Client) WithWSPing(d time.Duration) *Client {
+	c.wsPing = d
 	return c
 }
 
@@ -119,616 +326,4246 @@ func (c *Client) WithPollDuration(d time.Duration) *Client {
 	return c
 }
 
-func (c *Client) WithWSURL(url string) *Client {
-	c.wsurl = url
+// Replaces the time source used for negative cache
+// expiry and latest polling. Intended for tests.
+func (c *Client) WithClock(clk Clock) *Client {
+	c.clock = clk
 	return c
 }
 
-func (c *Client) debug(r io.Reader) io.Reader {
-	if !c.d {
-		return r
-	}
-	return io.TeeReader(r, os.Stdout)
+// When enabled, a trace failure part way through a range
+// causes Get to return the blocks that were traced
+// along with a *PartialError identifying the failed block.
+// Callers can then retry the remainder of the range.
+func (c *Client) WithPartialTraces(b bool) *Client {
+	c.partialTraces = b
+	return c
 }
 
-type request struct {
-	ID      string `json:"id"`
-	Version string `json:"jsonrpc"`
-	Method  string `json:"method"`
-	Params  []any  `json:"params"`
+// When set, the blocks and receipts batch requests
+// are decoded as the response streams in and f is called
+// with each block as soon as its data has been decoded.
+// Blocks passed to f have not yet been validated and
+// may be missing data from other requests made by Get.
+// This reduces peak memory for large batches.
+func (c *Client) WithStream(f func(*eth.Block)) *Client {
+	c.streamf = f
+	return c
 }
 
-func (c *Client) do(ctx context.Context, url string, dest, req any) error {
-	var (
-		eg   errgroup.Group
-		r, w = io.Pipe()
-		resp *http.Response
-	)
-	eg.Go(func() error {
-		defer w.Close()
-		return json.NewEncoder(w).Encode(req)
-	})
-	eg.Go(func() error {
-		req, err := http.NewRequest("POST", url, c.debug(r))
-		if err != nil {
-			return fmt.Errorf("unable to new request: %w", err)
-		}
-		req.Header.Add("content-type", "application/json")
-		resp, err = c.hc.Do(req)
-		if err != nil {
-			return fmt.Errorf("unable to do http request: %w", err)
-		}
-		return nil
-	})
-	if err := eg.Wait(); err != nil {
-		return err
-	}
-	if resp.StatusCode/100 != 2 {
-		b, _ := io.ReadAll(resp.Body)
-		text := strings.Map(func(r rune) rune {
-			if unicode.IsPrint(r) {
-				return r
-			}
-			return -1
-		}, string(b))
-		const msg = "rpc http error: %d %.100s"
-		return fmt.Errorf(msg, resp.StatusCode, text)
-	}
-	defer resp.Body.Close()
-	if err := json.NewDecoder(c.debug(resp.Body)).Decode(dest); err != nil {
-		return fmt.Errorf("unable to json decode: %w", err)
-	}
-	wctx.CounterAdd(ctx, 1)
-	return nil
+// When enabled, receipts missing status, gasUsed, or
+// effectiveGasPrice cause an error instead of
+// defaulting to zero.
+func (c *Client) WithRequireReceiptFields(b bool) *Client {
+	c.requireReceiptFields = b
+	return c
 }
 
-type Error struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+// When enabled, a null eth_getBlockReceipts result or a
+// block with transactions but no receipts causes Get to
+// return an error instead of logging and returning the
+// block without receipt data.
+func (c *Client) WithStrictReceipts(b bool) *Client {
+	c.strictReceipts = b
+	return c
 }
 
-func (e Error) Exists() bool {
-	return e.Code != 0
+// When enabled, a range whose eth_getLogs request fails is
+// returned without logs instead of returning an error.
+// This sacrifices completeness so it should only be used for
+// best effort indexes. Errors caused by a node that doesn't
+// have the range yet, and canceled requests, aren't skipped.
+// A range the node doesn't have yet is first retried a couple
+// of times, 100ms apart, so those errors are delayed by up to
+// 200ms. Combined batches (see [Client.WithCombinedBatches]) aren't
+// skipped. See [Client.WithSkippedLogsHook].
+func (c *Client) WithSkipUnfetchableLogs(b bool) *Client {
+	c.skipLogs = b
+	return c
 }
 
-func (e Error) Error() string {
-	return fmt.Sprintf("code=%d msg=%s", e.Code, e.Message)
+// f is called with each range skipped by WithSkipUnfetchableLogs
+func (c *Client) WithSkippedLogsHook(f func(start, limit uint64, err error)) *Client {
+	c.skipLogsHook = f
+	return c
 }
 
-type NumHash struct {
-	sync.Mutex
-	err      error
-	once     sync.Once
-	maxreads int
-	nreads   int
-	Num      eth.Uint64 `json:"number"`
-	Hash     eth.Bytes  `json:"hash"`
+// Reports whether the logs error for the range
+// should be skipped. See [Client.WithSkipUnfetchableLogs].
+func (c *Client) skipLogsErr(ctx context.Context, start, limit uint64, err error) bool {
+	if !c.skipLogs || ctx.Err() != nil || errors.Is(err, errMissingLogs) {
+		return false
+	}
+	c.logger().WarnContext(ctx, "skipping logs",
+		"start", start,
+		"limit", limit,
+		"error", err,
+	)
+	if c.skipLogsHook != nil {
+		c.skipLogsHook(start, limit, err)
+	}
+	return true
 }
 
-func (nh *NumHash) error(err error) {
-	nh.Lock()
-	nh.nreads = 0
-	nh.err = err
-	nh.Unlock()
+// When enabled, blocks fetched with full transactions
+// keep only the length of each tx's calldata.
+// See [eth.Tx.DataLength].
+func (c *Client) WithDiscardCalldata(b bool) *Client {
+	c.discardCalldata = b
+	return c
 }
 
-func (nh *NumHash) update(n eth.Uint64, h []byte) {
-	nh.Lock()
-	defer nh.Unlock()
-	if n <= nh.Num {
+func (c *Client) discard(b *eth.Block) {
+	if !c.discardCalldata {
 		return
 	}
-	nh.nreads = 0
-	nh.Num = n
-	nh.Hash.Write(h)
+	for i := range b.Txs {
+		b.Txs[i].DiscardData()
+	}
 }
 
-func (nh *NumHash) get(ctx context.Context, n uint64) (uint64, []byte, bool) {
-	nh.Lock()
-	defer nh.Unlock()
+// Range requests (Get, Hashes, TxCounts, and
+// HeadersWithTxHashes) with a limit greater than n return
+// an error instead of sending an n sized batch.
+// n == 0 removes the limit.
+func (c *Client) WithMaxLimit(n uint64) *Client {
+	c.maxLimit = n
+	return c
+}
 
-	if err := nh.err; err != nil {
-		switch {
-		case errors.Is(err, net.ErrClosed), errors.Is(err, context.DeadlineExceeded):
-			slog.DebugContext(ctx, "rpc connection reset")
-		default:
-			slog.DebugContext(ctx, "rpc connection error", "error", err)
-		}
-		nh.err = nil
-		nh.once = sync.Once{}
-		return 0, nil, false
+func (c *Client) checkLimit(limit uint64) error {
+	if c.maxLimit > 0 && limit > c.maxLimit {
+		return fmt.Errorf("limit %d exceeds max limit %d", limit, c.maxLimit)
 	}
+	return nil
+}
 
-	if n == 0 || uint64(nh.Num) < n {
-		slog.DebugContext(ctx, "latest cache miss", "n", n, "latest", nh.Num)
-		return 0, nil, false
-	}
+// When enabled, responses containing fields that the
+// client doesn't model cause an error instead of being
+// ignored. Useful in staging for noticing new fields
+// after a chain upgrade. Streamed responses aren't checked.
+// Fields that nodes return but that the client intentionally
+// doesn't model (eg a header's stateRoot) are allowed.
+func (c *Client) WithStrictJSON(b bool) *Client {
+	c.strictJSON = b
+	return c
+}
 
-	if nh.nreads >= nh.maxreads {
-		slog.DebugContext(ctx, "expiring latest cache",
-			"n", n,
-			"latest", nh.Num,
-			"nreads", nh.nreads,
-			"maxreads", nh.maxreads,
-		)
-		nh.nreads = 0
-		nh.Num = eth.Uint64(0)
-		nh.Hash.Write([]byte{})
-		return 0, nil, false
-	}
+// Calls f with the body of each successfully decoded HTTP
+// response, exactly as the provider sent it. method is the
+// comma separated list of methods in the request (or batch).
+// The body is copied as it is decoded so this buffers each
+// response in memory. raw must not be retained after f returns.
+// Disabled by default.
+func (c *Client) WithRawCapture(f func(method string, raw []byte)) *Client {
+	c.rawCapture = f
+	return c
+}
 
-	nh.nreads++
-	slog.DebugContext(ctx, "latest cache hit",
-		"n", n,
-		"latest", nh.Num,
-		"nreads", nh.nreads,
-	)
-	h := make([]byte, 32)
-	copy(h, nh.Hash)
-	return uint64(nh.Num), h, true
+// When enabled, Get requests blocks (or headers) and their
+// receipts or logs in a single batch request instead of
+// one batch per method. This saves a round trip per Get
+// but the provider must accept mixed method batches.
+// Ignored when streaming with WithStream.
+func (c *Client) WithCombinedBatches(b bool) *Client {
+	c.combineBatches = b
+	return c
 }
 
-func (c *Client) wsListen(ctx context.Context) {
-	ctx, cancel := context.WithTimeout(ctx, time.Minute)
-	defer cancel()
+// f is called with the method(s) and response headers after
+// each successful request. Useful for tracking provider
+// rate limit headers (eg x-ratelimit-remaining).
+func (c *Client) WithResponseHeaderHook(f func(method string, h http.Header)) *Client {
+	c.headerHook = f
+	return c
+}
 
-	wsc, _, err := websocket.Dial(ctx, c.wsurl, nil)
-	if err != nil {
-		c.lcache.error(fmt.Errorf("ws dial %q: %w", c.wsurl, err))
-		return
-	}
-	err = wsjson.Write(ctx, wsc, request{
-		ID:      "1",
-		Version: "2.0",
-		Method:  "eth_subscribe",
-		Params:  []any{"newHeads"},
-	})
-	if err != nil {
-		c.lcache.error(fmt.Errorf("ws write %q: %w", c.wsurl, err))
-		return
-	}
-	res := struct {
-		Error `json:"error"`
-		P     struct {
-			R NumHash `json:"result"`
-		} `json:"params"`
-	}{}
-	for {
-		if err := wsjson.Read(ctx, wsc, &res); err != nil {
-			c.lcache.error(fmt.Errorf("ws read %q: %w", c.wsurl, err))
-			return
-		}
-		slog.DebugContext(ctx, "websocket newHeads",
-			"n", res.P.R.Num,
-			"h", fmt.Sprintf("%.4x", res.P.R.Hash),
-		)
-		c.lcache.update(res.P.R.Num, res.P.R.Hash)
+// Get requests for ranges starting more than the archive depth
+// below the latest block are sent to these URLs
+// instead of the URL provided to Get.
+// The latest block is taken from the Latest cache
+// so routing only happens after Latest has been called.
+func (c *Client) WithArchiveURLs(urls ...string) *Client {
+	for _, u := range urls {
+		c.archiveURLs = append(c.archiveURLs, MustURL(u))
 	}
+	return c
 }
 
-func (c *Client) httpPoll(ctx context.Context, url string) {
-	var (
-		ticker = time.NewTicker(c.pollDuration)
-		hresp  = headerResp{}
-	)
-	defer ticker.Stop()
-	for range ticker.C {
-		err := c.do(ctx, url, &hresp, request{
-			ID:      "1",
-			Version: "2.0",
-			Method:  "eth_getBlockByNumber",
-			Params:  []any{"latest", false},
-		})
-		if err != nil {
-			c.lcache.error(err)
-			return
-		}
-		if hresp.Error.Exists() {
-			const tag = "eth_getBlockByNumber/latest"
-			c.lcache.error(fmt.Errorf("rpc=%s %w", tag, hresp.Error))
-			return
-		}
-		slog.DebugContext(ctx, "http poll",
-			"n", hresp.Number,
-			"h", fmt.Sprintf("%.4x", hresp.Hash),
-		)
-		c.lcache.update(hresp.Number, hresp.Hash)
-	}
+// Sets the number of blocks below the latest block
+// that are served by the primary URLs. Defaults to 128.
+func (c *Client) WithArchiveDepth(n uint64) *Client {
+	c.archiveDepth = n
+	return c
 }
 
-// Returns the latest block number/hash greater than n.
-// If n is lower than the cached block number,
-// returns the cached value; otherwise, fetches the
-// latest block. Caching is based on comparing n
-// with the cached block number, not on time/LRU methods.
-//
-// When n is 0, Latest always fetches the latest block
-// rather than using the cached value,
-// bypassing the caching mechanism.
-func (c *Client) Latest(ctx context.Context, url string, n uint64) (uint64, []byte, error) {
-	c.lcache.once.Do(func() {
-		switch {
-		case len(c.wsurl) > 0:
-			slog.DebugContext(ctx, "jrpc2 ws listening")
-			go c.wsListen(context.Background())
-		default:
-			slog.DebugContext(ctx, "jrpc2 http polling")
-			go c.httpPoll(context.Background(), url)
-		}
-	})
-	if n, h, ok := c.lcache.get(ctx, n); ok {
-		return n, h, nil
-	}
+// The name is included in errors returned by
+// the client and in its log lines. Useful when
+// running several clients in one process.
+func (c *Client) WithName(name string) *Client {
+	c.name = name
+	return c
+}
 
-	hresp := headerResp{}
-	err := c.do(ctx, url, &hresp, request{
-		ID:      fmt.Sprintf("latest-%d-%x", n, randbytes()),
-		Version: "2.0",
-		Method:  "eth_getBlockByNumber",
-		Params:  []any{"latest", false},
-	})
-	if err != nil {
-		return 0, nil, fmt.Errorf("unable request latest: %w", err)
-	}
-	if hresp.Error.Exists() {
-		const tag = "eth_getBlockByNumber/latest"
-		return 0, nil, fmt.Errorf("rpc=%s %w", tag, hresp.Error)
+func (c *Client) logger() *slog.Logger {
+	if c.name == "" {
+		return slog.Default()
 	}
-	slog.DebugContext(ctx, "http-get-latest",
-		"n", hresp.Number,
-		"h", fmt.Sprintf("%.4x", hresp.Hash),
-	)
-	c.lcache.update(hresp.Number, hresp.Hash)
-	return uint64(hresp.Number), hresp.Hash, nil
+	return slog.Default().With("client", c.name)
 }
 
-func (c *Client) Hash(ctx context.Context, url string, n uint64) ([]byte, error) {
-	hresp := headerResp{}
-	err := c.do(ctx, url, &hresp, request{
-		ID:      fmt.Sprintf("hash-%d-%x", n, randbytes()),
-		Version: "2.0",
-		Method:  "eth_getBlockByNumber",
-		Params:  []any{"0x" + strconv.FormatUint(n, 16), true},
-	})
-	if err != nil {
-		return nil, fmt.Errorf("unable request hash: %w", err)
+// Prefixes err with the url's hostname and the client's name.
+// Only the hostname is used so that credentials in the
+// url aren't leaked into logs.
+func (c *Client) wrap(err *error, url string) {
+	if *err == nil {
+		return
 	}
-	if hresp.Error.Exists() {
-		const tag = "eth_getBlockByNumber/hash"
-		return nil, fmt.Errorf("rpc=%s %w", tag, hresp.Error)
+	if h := hostname(url); h != "" {
+		*err = fmt.Errorf("host=%s %w", h, *err)
+	}
+	if c.name != "" {
+		*err = fmt.Errorf("%s: %w", c.name, *err)
 	}
-	return hresp.Hash, nil
+}
+
+func (c *Client) WithWSURL(url string) *Client {
+	c.wsurls = nil
+	if len(url) > 0 {
+		c.wsurls = []string{url}
+	}
+	return c
+}
+
+// Subscribes to newHeads on each url. Every connection
+// updates the latest block cache so the first provider
+// to see a new block wins. Each connection reconnects
+// independently.
+func (c *Client) WithWSURLs(urls ...string) *Client {
+	c.wsurls = slices.Clone(urls)
+	return c
+}
+
+// Replaces the client's http.Client. This supersedes
+// WithGzip, WithTLSConfig, and WithInsecureSkipVerify
+// since those configure the default transport.
+func (c *Client) WithHTTPClient(hc *http.Client) *Client {
+	c.hc = hc
+	c.customHC = true
+	return c
+}
+
+// Request bodies are buffered by default so that they can
+// be replayed. WithStreamingRequests(true) encodes the body
+// while it is being sent instead, which uses less memory for
+// very large batches but prevents retries.
+func (c *Client) WithStreamingRequests(b bool) *Client {
+	c.streamReqs = b
+	return c
+}
+
+// Splits eth_getLogs requests into parallel requests of at
+// most n addresses each. This helps when a single chatty
+// address causes the combined request to exceed the
+// provider's result limit. Disabled by default.
+func (c *Client) WithLogsAddressSplit(n int) *Client {
+	c.logsAddrGroup = n
+	return c
+}
+
+// Some devnets and L2s skip block numbers. WithNumberGaps(true)
+// ignores the check that the first and last blocks match the
+// requested range and relies on parent hash linkage alone.
+// The default strict behavior is recommended for standard chains.
+func (c *Client) WithNumberGaps(b bool) *Client {
+	c.allowGaps = b
+	return c
+}
+
+// Adjusts the http polling interval to a fraction of the
+// observed block time, bounded by min and max.
+// Polling starts at the poll duration. See [Client.WithPollDuration].
+func (c *Client) WithAdaptivePoll(min, max time.Duration) *Client {
+	c.pollMin, c.pollMax = min, max
+	return c
+}
+
+// Latest reports tip-k instead of the tip.
+// See [Client.Latest].
+func (c *Client) WithConfirmations(k uint64) *Client {
+	c.confirmations = k
+	return c
+}
+
+// Segment validation is enabled by default and checks that
+// each block's parent hash matches the previous block's hash.
+// WithSegmentValidation(false) skips the linkage check but
+// still checks the first and last block numbers.
+func (c *Client) WithSegmentValidation(b bool) *Client {
+	c.skipLinkage = !b
+	return c
+}
+
+// When enabled, Get recomputes the transactions root from
+// the block's raw transactions and the receipts root from
+// the block's receipts and returns a *RootError when either
+// doesn't match the header. Roots are only checked when the
+// filter requests blocks (transactions) or headers and
+// receipts (receipts). Checking the transactions root costs
+// an extra request per transaction. Disabled by default.
+func (c *Client) WithVerifyRoots(b bool) *Client {
+	c.verifyRoots = b
+	return c
+}
+
+// Sets the jsonrpc field on all requests. Defaults to "2.0".
+// An empty string omits the field for endpoints that reject it.
+func (c *Client) WithJSONRPCVersion(s string) *Client {
+	c.version = s
+	return c
+}
+
+// Selects the method used to fetch traces. Supported
+// methods are trace_block (default) and
+// trace_replayBlockTransactions. Panics on any other
+// non-empty method.
+func (c *Client) WithTraceMethod(m string) *Client {
+	switch m {
+	case "", "trace_block", "trace_replayBlockTransactions":
+	default:
+		panic(fmt.Sprintf("unknown trace method %q. want trace_block or trace_replayBlockTransactions", m))
+	}
+	c.traceMethod = m
+	return c
+}
+
+// Selects how the latest block cache is kept up to date.
+// "http" polls eth_getBlockByNumber even when websocket
+// urls are configured. "ws" subscribes to newHeads. The
+// default uses websockets when a websocket url is set.
+// Panics when src isn't "", "http", or "ws".
+func (c *Client) WithLatestSource(src string) *Client {
+	switch src {
+	case "", "http", "ws":
+	default:
+		panic(fmt.Sprintf("unknown latest source %q. want http or ws", src))
+	}
+	c.latestSource = src
+	return c
+}
+
+// Limits requests to url to rps with bursts of up to burst.
+// rps <= 0 removes the limit. Must be called before the
+// client is used.
+func (c *Client) WithRateLimit(url string, rps float64, burst int) *Client {
+	if c.limits == nil {
+		c.limits = make(map[string]*rate.Limiter)
+	}
+	u := MustURL(url).String()
+	delete(c.limits, u)
+	if rps > 0 {
+		c.limits[u] = rate.NewLimiter(rate.Limit(rps), max(burst, 1))
+	}
+	return c
+}
+
+// Declares the methods that url supports. Get sends
+// requests that url can't serve to the first configured
+// url that can. Urls without a declaration are assumed
+// to support every method.
+func (c *Client) WithMethodSupport(url string, methods []string) *Client {
+	if c.methodSupport == nil {
+		c.methodSupport = make(map[string][]string)
+	}
+	c.methodSupport[MustURL(url).String()] = slices.Clone(methods)
+	return c
+}
+
+// Bounds the number of in-flight requests across all
+// methods and callers. n <= 0 removes the limit.
+func (c *Client) WithMaxConcurrentRequests(n int) *Client {
+	c.sem = nil
+	if n > 0 {
+		c.sem = semaphore.NewWeighted(int64(n))
+	}
+	return c
+}
+
+// Gzip is enabled by default. Some providers and proxies
+// mangle compressed responses; WithGzip(false) disables
+// compression negotiation entirely.
+func (c *Client) WithGzip(b bool) *Client {
+	if c.customHC {
+		return c
+	}
+	c.tr.DisableCompression = !b
+	if b {
+		c.hc.Transport = gzhttp.Transport(c.tr)
+	} else {
+		c.hc.Transport = c.tr
+	}
+	return c
+}
+
+// Sets the TLS config on the client's underlying transport.
+// The transport is wrapped by the gzip transport so the
+// config applies to both compressed and plain requests.
+// Intended for self-hosted nodes using private CAs.
+func (c *Client) WithTLSConfig(cfg *tls.Config) *Client {
+	c.tr.TLSClientConfig = cfg
+	return c
+}
+
+// Disables TLS certificate verification. Only use this
+// for nodes with self-signed certificates on a trusted network.
+func (c *Client) WithInsecureSkipVerify(b bool) *Client {
+	if c.tr.TLSClientConfig == nil {
+		c.tr.TLSClientConfig = &tls.Config{}
+	}
+	c.tr.TLSClientConfig.InsecureSkipVerify = b
+	return c
+}
+
+// Disables the segment and latest caches so that every
+// Get and Latest makes requests to the node. The
+// background latest poller isn't started.
+func (c *Client) WithNoCache(b bool) *Client {
+	c.nocache = b
+	return c
+}
+
+// When n is positive, each Get is split into batches whose
+// size adapts to the largest response of the recent batches
+// so that responses are about n bytes. The batch size starts
+// at 10 blocks and is kept between 1 and the max limit (see
+// [Client.WithMaxLimit]) or 1000 when there isn't one. Each
+// filter has its own batch size since response sizes vary
+// widely between, say, headers and receipts.
+func (c *Client) WithTargetBatchBytes(n int) *Client {
+	c.batch.target = int64(n)
+	return c
+}
+
+// Sets the cost, in the provider's units (e.g. compute
+// units), of n requests for method. The cost is only used
+// when Get is called with a budget (see [WithBudget]).
+func (c *Client) WithCostModel(f func(method string, n int) int) *Client {
+	c.cost = f
+	return c
+}
+
+// Blocks at or below the finalized head are saved in store
+// and later Get calls for the same filter read them from
+// store instead of the node. See [BlockStore].
+func (c *Client) WithBlockCache(store BlockStore) *Client {
+	c.store = store
+	return c
+}
+
+// See [Client.SetDebug]
+func (c *Client) WithDebug(b bool) *Client {
+	c.SetDebug(b)
+	return c
+}
+
+// Enables or disables request and response dumping.
+// Safe to call while the client is in use.
+func (c *Client) SetDebug(b bool) {
+	c.d.Store(b)
+}
+
+// Raw request and response bodies are written to w
+// instead of being logged when debug is enabled.
+func (c *Client) WithDebugWriter(w io.Writer) *Client {
+	c.dw = w
+	return c
+}
+
+// Sets the maximum number of request and response bytes
+// included in debug logs. Defaults to 1024.
+func (c *Client) WithDebugMaxBytes(n int) *Client {
+	c.dmax = n
+	return c
+}
+
+// Captures the first max bytes written to it
+// while counting the total.
+type debugBuf struct {
+	max int
+	n   int
+	b   []byte
+}
+
+func (d *debugBuf) Write(p []byte) (int, error) {
+	d.n += len(p)
+	if rem := d.max - len(d.b); rem > 0 {
+		d.b = append(d.b, p[:min(rem, len(p))]...)
+	}
+	return len(p), nil
+}
+
+func (d *debugBuf) String() string {
+	s := strings.TrimSpace(string(d.b))
+	if d.n > len(d.b) {
+		return fmt.Sprintf("%s...(%d bytes)", s, d.n)
+	}
+	return s
+}
+
+func (c *Client) debug(on bool, r io.Reader, d *debugBuf) io.Reader {
+	switch {
+	case !on:
+		return r
+	case c.dw != nil:
+		return io.TeeReader(r, c.dw)
+	default:
+		return io.TeeReader(r, d)
+	}
+}
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// Returns a comma separated list of the
+// unique methods in a request or batch request.
+func methods(req any) string {
+	switch r := req.(type) {
+	case request:
+		return r.Method
+	case []request:
+		var res []string
+		for i := range r {
+			if !slices.Contains(res, r[i].Method) {
+				res = append(res, r[i].Method)
+			}
+		}
+		return strings.Join(res, ",")
+	default:
+		return ""
+	}
+}
+
+func hostname(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return ""
+	}
+	if path, ok := socketPath(u.Hostname()); ok {
+		return "unix:" + path
+	}
+	return u.Hostname()
+}
+
+type request struct {
+	ID      string `json:"id"`
+	Version string `json:"jsonrpc,omitempty"`
+	Method  string `json:"method"`
+	Params  []any  `json:"params"`
+}
+
+func (c *Client) do(ctx context.Context, url string, dest, req any) error {
+	if url == "" {
+		return ErrNoURLs
+	}
+	if l, ok := c.limits[url]; ok {
+		if err := l.Wait(ctx); err != nil {
+			return fmt.Errorf("waiting for rate limit: %w", err)
+		}
+	}
+	if c.sem != nil {
+		if err := c.sem.Acquire(ctx, 1); err != nil {
+			return fmt.Errorf("waiting for request slot: %w", err)
+		}
+		defer c.sem.Release(1)
+	}
+	var (
+		t0    = time.Now()
+		eg    errgroup.Group
+		resp  *http.Response
+		sent  = &countReader{}
+		debug = c.d.Load()
+
+		dreq  = &debugBuf{max: c.dmax}
+		dresp = &debugBuf{max: c.dmax}
+	)
+	send := func(body io.Reader) error {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+		if err != nil {
+			return fmt.Errorf("unable to new request: %w", err)
+		}
+		req.Header.Add("content-type", "application/json")
+		resp, err = c.hc.Do(req)
+		if err != nil {
+			return fmt.Errorf("unable to do http request: %w", err)
+		}
+		return nil
+	}
+	switch {
+	case c.streamReqs:
+		// The body is encoded as it is sent which avoids
+		// buffering large batches but can't be replayed.
+		r, w := io.Pipe()
+		eg.Go(func() error {
+			defer w.Close()
+			return json.NewEncoder(w).Encode(req)
+		})
+		sent.r = r
+		eg.Go(func() error {
+			return send(c.debug(debug, sent, dreq))
+		})
+	default:
+		// A buffered body sets GetBody on the request
+		// so that it can be resent on retries and redirects.
+		buf := &bytes.Buffer{}
+		if err := json.NewEncoder(buf).Encode(req); err != nil {
+			return fmt.Errorf("unable to json encode: %w", err)
+		}
+		if debug {
+			io.Copy(io.Discard, c.debug(debug, bytes.NewReader(buf.Bytes()), dreq))
+		}
+		sent.n = int64(buf.Len())
+		eg.Go(func() error {
+			return send(bytes.NewReader(buf.Bytes()))
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	rbody := &countReader{r: resp.Body}
+	defer func() {
+		c.methodStats(methods(req)).add(uint64(sent.n), uint64(rbody.n))
+		if rs, ok := ctx.Value(respSizeKey{}).(*respSize); ok {
+			rs.add(rbody.n)
+		}
+		c.logger().DebugContext(ctx, "jrpc2-do",
+			"method", methods(req),
+			"host", hostname(url),
+			"status", resp.StatusCode,
+			"bytes", rbody.n,
+			"elapsed", time.Since(t0),
+		)
+	}()
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(rbody)
+		text := strings.Map(func(r rune) rune {
+			if unicode.IsPrint(r) {
+				return r
+			}
+			return -1
+		}, string(b))
+		const msg = "rpc http error: %d %.100s"
+		return fmt.Errorf(msg, resp.StatusCode, text)
+	}
+	var (
+		captured *bytes.Buffer
+		src      io.Reader = rbody
+	)
+	if c.rawCapture != nil {
+		captured = &bytes.Buffer{}
+		src = io.TeeReader(rbody, captured)
+	}
+	body := bufio.NewReader(c.debug(debug, src, dresp))
+	var raw []byte
+	if c.strictJSON {
+		var err error
+		raw, err = io.ReadAll(body)
+		if err != nil {
+			return fmt.Errorf("reading response: %w", err)
+		}
+		body = bufio.NewReader(bytes.NewReader(raw))
+	}
+	fb := firstByte(body)
+	if fb == 0 {
+		return fmt.Errorf("rpc=%s %w", methods(req), ErrEmptyResponse)
+	}
+	if _, ok := req.([]request); ok && fb == '{' {
+		// Some providers respond to a bad batch request
+		// with a single error object instead of an array.
+		eresp := struct {
+			Error Error `json:"error"`
+		}{}
+		if err := json.NewDecoder(body).Decode(&eresp); err != nil {
+			return fmt.Errorf("unable to json decode: %w", err)
+		}
+		if eresp.Error.Exists() {
+			return fmt.Errorf("rpc=%s %w", methods(req), eresp.Error)
+		}
+		return fmt.Errorf("rpc=%s expected batch response array", methods(req))
+	}
+	dec := json.NewDecoder(body)
+	switch f := dest.(type) {
+	case stream:
+		if err := f.decode(dec); err != nil {
+			return fmt.Errorf("unable to json stream decode: %w", err)
+		}
+	case byID:
+		if err := f.decode(dec); err != nil {
+			return fmt.Errorf("unable to json decode: %w", err)
+		}
+	default:
+		if err := dec.Decode(dest); err != nil {
+			return fmt.Errorf("unable to json decode: %w", err)
+		}
+	}
+	if c.strictJSON {
+		if err := strictCheck(raw, dest); err != nil {
+			return fmt.Errorf("rpc=%s strict json: %w", methods(req), err)
+		}
+	}
+	if debug && c.dw == nil {
+		c.logger().DebugContext(ctx, "jrpc2-debug",
+			"method", methods(req),
+			"host", hostname(url),
+			"req", dreq.String(),
+			"resp", dresp.String(),
+		)
+	}
+	if c.headerHook != nil {
+		c.headerHook(methods(req), resp.Header)
+	}
+	if c.rawCapture != nil {
+		// The decoder may stop before the end of the body
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return fmt.Errorf("reading response: %w", err)
+		}
+		c.rawCapture(methods(req), captured.Bytes())
+	}
+	countCalls(ctx, req)
+	return nil
+}
+
+type budgetKey struct{}
+
+type budget struct {
+	sync.Mutex
+	remaining int
+}
+
+// Returns a context that limits the cost (see
+// [Client.WithCostModel]) of the requests made by Get
+// with it to units. Get shrinks its batches to fit the
+// remaining budget. When the budget runs out part way
+// through a range, Get returns the blocks it has along
+// with a *PartialError wrapping ErrOverBudget. Requests
+// answered by the cache are free. The budget is shared by
+// concurrent calls with the context and is spent when the
+// requests are made, even if they fail.
+func WithBudget(ctx context.Context, units int) context.Context {
+	return context.WithValue(ctx, budgetKey{}, &budget{remaining: units})
+}
+
+// Returns the units left in ctx's budget.
+// ok is false when ctx doesn't have a budget.
+func BudgetRemaining(ctx context.Context) (int, bool) {
+	b, ok := ctx.Value(budgetKey{}).(*budget)
+	if !ok {
+		return 0, false
+	}
+	b.Lock()
+	defer b.Unlock()
+	return b.remaining, true
+}
+
+// Spends the cost of the largest batch, halving n, that
+// fits in the budget. Returns 0 when one block doesn't fit.
+func (b *budget) reserve(n uint64, cost func(uint64) int) uint64 {
+	b.Lock()
+	defer b.Unlock()
+	for ; n > 0; n /= 2 {
+		if c := cost(n); c <= b.remaining {
+			b.remaining -= c
+			return n
+		}
+	}
+	return 0
+}
+
+// Estimates the cost of a getBatch of n blocks using the
+// methods from filterMethods. The raw transactions requested
+// by WithVerifyRoots aren't included.
+func (c *Client) batchCost(f *glf.Filter, n uint64) int {
+	var res int
+	if f.UseBlocks || f.UseHeaders {
+		res += c.cost("eth_getBlockByNumber", int(n))
+	}
+	switch {
+	case f.UseReceipts:
+		res += c.cost("eth_getBlockReceipts", int(n))
+	case f.UseLogs:
+		// Logs are requested once per address group
+		// regardless of the size of the range.
+		lf := logFilter{Address: f.Addresses()}
+		res += c.cost("eth_getLogs", len(lf.split(c.logsAddrGroup)))
+		res += c.cost("eth_getBlockByNumber", 1)
+	case f.UseTraces:
+		m := "trace_block"
+		if c.traceMethod == "trace_replayBlockTransactions" {
+			m = c.traceMethod
+		}
+		res += c.cost(m, int(n))
+	}
+	return res
+}
+
+type respSizeKey struct{}
+
+// Records the largest response made with a context.
+// See [Client.WithTargetBatchBytes].
+type respSize struct {
+	sync.Mutex
+	max int64
+}
+
+func (rs *respSize) add(n int64) {
+	rs.Lock()
+	defer rs.Unlock()
+	rs.max = max(rs.max, n)
+}
+
+// Adds the number of calls in req, rather than the number
+// of http requests, to the context's counters so that a
+// batch is accounted for by its size.
+func countCalls(ctx context.Context, req any) {
+	switch r := req.(type) {
+	case request:
+		wctx.CounterAdd(ctx, 1)
+		wctx.MethodCounterAdd(ctx, r.Method, 1)
+	case []request:
+		wctx.CounterAdd(ctx, uint64(len(r)))
+		counts := map[string]uint64{}
+		for i := range r {
+			counts[r[i].Method]++
+		}
+		for m, n := range counts {
+			wctx.MethodCounterAdd(ctx, m, n)
+		}
+	default:
+		wctx.CounterAdd(ctx, 1)
+	}
+}
+
+// Decodes raw a second time, into new values of the same
+// types as dest, with unknown fields disallowed.
+func strictCheck(raw []byte, dest any) error {
+	switch d := dest.(type) {
+	case stream:
+		return nil
+	case byID:
+		var objs []map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &objs); err != nil {
+			return err
+		}
+		for _, obj := range objs {
+			var id string
+			if err := json.Unmarshal(obj["id"], &id); err != nil {
+				return err
+			}
+			if err := strictObject(obj, reflect.TypeOf(d[id])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	t := reflect.TypeOf(dest)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Slice {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return err
+		}
+		return strictObject(obj, t)
+	}
+	var objs []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &objs); err != nil {
+		return err
+	}
+	// a []any dest holds a pointer per response
+	v := reflect.Indirect(reflect.ValueOf(dest))
+	for i, obj := range objs {
+		et := t.Elem()
+		if et.Kind() == reflect.Interface && i < v.Len() {
+			et = v.Index(i).Elem().Type()
+		}
+		if err := strictObject(obj, et); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The jsonrpc envelope fields are allowed
+// since the response types don't model them.
+func strictObject(obj map[string]json.RawMessage, t reflect.Type) error {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	delete(obj, "jsonrpc")
+	delete(obj, "id")
+	if err := stripUnmodeled(obj, t); err != nil {
+		return err
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	return dec.Decode(reflect.New(t).Interface())
+}
+
+// Fields that nodes return but that are intentionally
+// not modeled. Strict mode allows them so that it only
+// reports fields that are actually new.
+var unmodeled = map[reflect.Type][]string{
+	reflect.TypeOf(eth.Header{}): {
+		"miner",
+		"stateRoot",
+		"sha3Uncles",
+		"extraData",
+		"totalDifficulty",
+		"withdrawals",
+		"withdrawalsRoot",
+		"blobGasUsed",
+		"excessBlobGas",
+		"parentBeaconBlockRoot",
+		"requestsHash",
+	},
+	reflect.TypeOf(eth.Tx{}): {
+		"blockHash",
+		"blockNumber",
+	},
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// Removes the unmodeled fields of t, and of the
+// types nested in t, from obj.
+func stripUnmodeled(obj map[string]json.RawMessage, t reflect.Type) error {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	for _, k := range unmodeled[t] {
+		delete(obj, k)
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch {
+		case name == "-", !f.IsExported() && !f.Anonymous:
+			continue
+		case f.Anonymous && name == "":
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if err := stripUnmodeled(obj, ft); err != nil {
+					return err
+				}
+			}
+			continue
+		case name == "":
+			name = f.Name
+		}
+		for k, raw := range obj {
+			if !strings.EqualFold(k, name) {
+				continue
+			}
+			b, err := stripRaw(raw, f.Type)
+			if err != nil {
+				return err
+			}
+			obj[k] = b
+		}
+	}
+	return nil
+}
+
+// Calls stripUnmodeled on each object in raw.
+// Types with their own decoding are left as is.
+func stripRaw(raw json.RawMessage, t reflect.Type) (json.RawMessage, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(unmarshalerType) {
+		return raw, nil
+	}
+	b := bytes.TrimSpace(raw)
+	switch {
+	case t.Kind() == reflect.Slice && len(b) > 0 && b[0] == '[':
+		var elems []json.RawMessage
+		if err := json.Unmarshal(b, &elems); err != nil {
+			return nil, err
+		}
+		for i := range elems {
+			e, err := stripRaw(elems[i], t.Elem())
+			if err != nil {
+				return nil, err
+			}
+			elems[i] = e
+		}
+		return json.Marshal(elems)
+	case t.Kind() == reflect.Struct && len(b) > 0 && b[0] == '{':
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(b, &obj); err != nil {
+			return nil, err
+		}
+		if err := stripUnmodeled(obj, t); err != nil {
+			return nil, err
+		}
+		return json.Marshal(obj)
+	}
+	return raw, nil
+}
+
+// returns the first non-whitespace byte in r
+// without consuming it. returns 0 on EOF.
+func firstByte(r *bufio.Reader) byte {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return 0
+		}
+		switch b[0] {
+		case ' ', '\t', '\n', '\r':
+			r.ReadByte()
+		default:
+			return b[0]
+		}
+	}
+}
+
+// When passed as the dest to do, a batch response
+// is decoded one element at a time by calling the func
+// for each element in the response array.
+type stream func(dec *json.Decoder) error
+
+func (f stream) decode(dec *json.Decoder) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := t.(json.Delim); !ok || d != '[' {
+		return fmt.Errorf("expected batch response array. got: %v", t)
+	}
+	for dec.More() {
+		if err := f(dec); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// When passed as the dest to do, each element of a batch
+// response is decoded into the value registered for its id.
+// This allows a batch to mix methods with different result
+// types and doesn't depend on the order of the responses.
+type byID map[string]any
+
+// Returns *ErrIncompleteBatch when any of the ids
+// didn't receive a response.
+func (m byID) decode(dec *json.Decoder) error {
+	got := make(map[string]struct{}, len(m))
+	err := stream(func(dec *json.Decoder) error {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		id := struct {
+			ID string `json:"id"`
+		}{}
+		if err := json.Unmarshal(raw, &id); err != nil {
+			return err
+		}
+		dest, ok := m[id.ID]
+		if !ok {
+			return fmt.Errorf("unexpected response id: %q", id.ID)
+		}
+		got[id.ID] = struct{}{}
+		return json.Unmarshal(raw, dest)
+	}).decode(dec)
+	if err != nil {
+		return err
+	}
+	if len(got) < len(m) {
+		return &ErrIncompleteBatch{Want: len(m), Got: len(got)}
+	}
+	return nil
+}
+
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Some providers respond with the error as a string
+// instead of an object. These are decoded with a code
+// of -1 and the string as the message.
+func (e *Error) UnmarshalJSON(b []byte) error {
+	if b = bytes.TrimSpace(b); len(b) == 0 || b[0] != '"' {
+		type object Error
+		return json.Unmarshal(b, (*object)(e))
+	}
+	var msg string
+	if err := json.Unmarshal(b, &msg); err != nil {
+		return err
+	}
+	e.Code, e.Message = -1, msg
+	return nil
+}
+
+func (e Error) Exists() bool {
+	return e.Code != 0
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("code=%d msg=%s", e.Code, e.Message)
+}
+
+// Returned (wrapped) when a node doesn't support a method.
+var (
+	ErrMethodNotFound = errors.New("method not found")
+	ErrNotFound       = errors.New("not found")
+	ErrNoURLs         = errors.New("no urls configured")
+
+	// The node responded with a 2xx status and an empty
+	// (or whitespace only) body. Usually transient.
+	ErrEmptyResponse = errors.New("empty response")
+
+	// The context's budget can't pay for another
+	// request. See [WithBudget].
+	ErrOverBudget = errors.New("over budget")
+
+	errMissingLogs = errors.New("eth backend missing logs")
+)
+
+// Reports whether e is a JSON-RPC method not found error
+// so that errors.Is(err, ErrMethodNotFound) works.
+func (e Error) Is(target error) bool {
+	return target == ErrMethodNotFound && e.Code == -32601
+}
+
+// Returned when a provider responds to a batch with
+// fewer responses than requests.
+// The batch can be retried.
+type ErrIncompleteBatch struct {
+	Want, Got int
+}
+
+func (e *ErrIncompleteBatch) Error() string {
+	return fmt.Sprintf("incomplete batch. want: %d responses got: %d", e.Want, e.Got)
+}
+
+// Returned by Get when partial traces are enabled.
+// Blocks before Num were successfully traced.
+type PartialError struct {
+	Num uint64
+	Err error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("partial result. failed at block %d: %s", e.Num, e.Err)
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+type NumHash struct {
+	sync.Mutex
+	err      error
+	once     sync.Once
+	maxreads int
+	nreads   int
+	maxAge   time.Duration
+	updated  time.Time
+	Num      eth.Uint64 `json:"number"`
+	Hash     eth.Bytes  `json:"hash"`
+}
+
+func (nh *NumHash) error(err error) {
+	nh.Lock()
+	nh.nreads = 0
+	nh.err = err
+	nh.Unlock()
+}
+
+// now is recorded even when n isn't new since it
+// shows that the source of n is still alive.
+func (nh *NumHash) update(n eth.Uint64, h []byte, now time.Time) {
+	nh.Lock()
+	defer nh.Unlock()
+	nh.updated = now
+	if n <= nh.Num {
+		return
+	}
+	nh.nreads = 0
+	nh.Num = n
+	nh.Hash.Write(h)
+}
+
+func (nh *NumHash) get(nocache bool, ctx context.Context, n uint64, now time.Time) (uint64, []byte, bool) {
+	if nocache {
+		return 0, nil, false
+	}
+	nh.Lock()
+	defer nh.Unlock()
+
+	if err := nh.err; err != nil {
+		switch {
+		case errors.Is(err, net.ErrClosed), errors.Is(err, context.DeadlineExceeded):
+			slog.DebugContext(ctx, "rpc connection reset")
+		default:
+			slog.DebugContext(ctx, "rpc connection error", "error", err)
+		}
+		nh.err = nil
+		nh.once = sync.Once{}
+		return 0, nil, false
+	}
+
+	if n == 0 || uint64(nh.Num) < n {
+		slog.DebugContext(ctx, "latest cache miss", "n", n, "latest", nh.Num)
+		return 0, nil, false
+	}
+
+	if age := now.Sub(nh.updated); nh.maxAge > 0 && age > nh.maxAge {
+		slog.DebugContext(ctx, "stale latest cache",
+			"n", n,
+			"latest", nh.Num,
+			"age", age,
+		)
+		return 0, nil, false
+	}
+
+	if nh.nreads >= nh.maxreads {
+		slog.DebugContext(ctx, "expiring latest cache",
+			"n", n,
+			"latest", nh.Num,
+			"nreads", nh.nreads,
+			"maxreads", nh.maxreads,
+		)
+		nh.nreads = 0
+		nh.Num = eth.Uint64(0)
+		nh.Hash.Write([]byte{})
+		return 0, nil, false
+	}
+
+	nh.nreads++
+	slog.DebugContext(ctx, "latest cache hit",
+		"n", n,
+		"latest", nh.Num,
+		"nreads", nh.nreads,
+	)
+	h := make([]byte, 32)
+	copy(h, nh.Hash)
+	return uint64(nh.Num), h, true
+}
+
+const (
+	// consecutive websocket failures before polling over http
+	maxWSFailures = 3
+	// how long to poll over http before retrying the websocket
+	wsFallbackDuration = time.Minute
+)
+
+// Prefers websocket newHeads for updating the latest cache.
+// After maxWSFailures consecutive websocket failures it
+// polls url over http for wsFallbackDuration before
+// trying the websocket again. Both update the same cache
+// so callers of Latest aren't affected by the switch.
+func (c *Client) listen(ctx context.Context, url, wsurl string) {
+	var failures int
+	for ctx.Err() == nil {
+		n, err := c.wsListen(ctx, wsurl)
+		if n > 0 {
+			failures = 0
+		}
+		if err != nil {
+			failures++
+			c.logger().DebugContext(ctx, "websocket", "failures", failures, "error", err)
+		}
+		if failures < maxWSFailures {
+			time.Sleep(c.pollDuration)
+			continue
+		}
+		c.logger().DebugContext(ctx, "websocket fallback to http polling")
+		pctx, cancel := context.WithTimeout(ctx, wsFallbackDuration)
+		err = c.httpPoll(pctx, url)
+		cancel()
+		if err != nil {
+			c.logger().DebugContext(ctx, "http poll", "error", err)
+			time.Sleep(c.pollDuration)
+		}
+	}
+}
+
+// Streams logs matching filter's addresses and topics from
+// the websocket url to fn until ctx is done. Reconnects and
+// re-subscribes on disconnect, waiting the poll duration
+// between attempts. Logs that were already delivered before
+// a reconnect are skipped. Removed (reorged) logs are skipped.
+func (c *Client) SubscribeLogs(ctx context.Context, filter *glf.Filter, fn func(eth.Log)) error {
+	var (
+		params = struct {
+			Address []string   `json:"address"`
+			Topics  [][]string `json:"topics"`
+		}{filter.Addresses(), filter.Topics()}
+		ls = &logSeen{}
+	)
+	return c.resubscribe(ctx, "SubscribeLogs", func(wsurl string) error {
+		return c.wsLogs(ctx, wsurl, params, ls, fn)
+	})
+}
+
+// Streams the hashes of pending transactions from the
+// websocket url to fn until ctx is done. Reconnects and
+// re-subscribes on disconnect, waiting the poll duration
+// between attempts. Hashes seen while disconnected are lost.
+func (c *Client) SubscribePendingTxs(ctx context.Context, fn func(hash []byte)) error {
+	return c.resubscribe(ctx, "SubscribePendingTxs", func(wsurl string) error {
+		wsc, err := c.wsSubscribe(ctx, wsurl, "newPendingTransactions")
+		if err != nil {
+			return err
+		}
+		defer wsc.CloseNow()
+		defer c.wsKeepalive(ctx, wsc)()
+		for {
+			res := struct {
+				P struct {
+					R eth.Bytes `json:"result"`
+				} `json:"params"`
+			}{}
+			if err := wsjson.Read(ctx, wsc, &res); err != nil {
+				return fmt.Errorf("ws read %q: %w", wsurl, err)
+			}
+			if len(res.P.R) > 0 {
+				fn(res.P.R)
+			}
+		}
+	})
+}
+
+// Calls f with the first websocket url until ctx is done,
+// waiting the poll duration between attempts.
+func (c *Client) resubscribe(ctx context.Context, name string, f func(wsurl string) error) error {
+	if len(c.wsurls) == 0 {
+		return fmt.Errorf("%s requires a websocket url", name)
+	}
+	wsurl := c.wsurls[0]
+	for {
+		err := f(wsurl)
+		if ctx.Err() != nil {
+			return nil
+		}
+		c.logger().DebugContext(ctx, "ws reconnecting", "sub", name, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(c.pollDuration):
+		}
+	}
+}
+
+// Dials wsurl and waits for the eth_subscribe response.
+// The caller must close the returned connection.
+func (c *Client) wsSubscribe(ctx context.Context, wsurl string, params ...any) (*websocket.Conn, error) {
+	wsc, _, err := websocket.Dial(ctx, wsurl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ws dial %q: %w", wsurl, err)
+	}
+	err = wsjson.Write(ctx, wsc, request{
+		ID:      "1",
+		Version: c.version,
+		Method:  "eth_subscribe",
+		Params:  params,
+	})
+	if err != nil {
+		wsc.CloseNow()
+		return nil, fmt.Errorf("ws write %q: %w", wsurl, err)
+	}
+	sub := struct {
+		Error  Error  `json:"error"`
+		Result string `json:"result"`
+	}{}
+	if err := wsjson.Read(ctx, wsc, &sub); err != nil {
+		wsc.CloseNow()
+		return nil, fmt.Errorf("ws read %q: %w", wsurl, err)
+	}
+	if sub.Error.Exists() {
+		wsc.CloseNow()
+		return nil, fmt.Errorf("rpc=eth_subscribe/%v %w", params[0], sub.Error)
+	}
+	return wsc, nil
+}
+
+// Number of blocks below the highest block that logSeen remembers
+const logSeenDepth = 64
+
+type logSeenKey struct {
+	hash string
+	idx  uint64
+}
+
+// Tracks the logs delivered for recent blocks so that logs
+// replayed after a reconnect are skipped. Logs are keyed by
+// block hash so that a reorg's replacement logs, which may
+// reuse the same heights and indexes, are still delivered.
+// Logs more than logSeenDepth blocks below the highest
+// block are forgotten and treated as replays.
+type logSeen struct {
+	max  uint64
+	seen map[logSeenKey]uint64 // block number
+}
+
+func (ls *logSeen) add(num uint64, hash []byte, idx uint64) bool {
+	if num+logSeenDepth < ls.max {
+		return false
+	}
+	k := logSeenKey{string(hash), idx}
+	if _, ok := ls.seen[k]; ok {
+		return false
+	}
+	if ls.seen == nil {
+		ls.seen = map[logSeenKey]uint64{}
+	}
+	ls.seen[k] = num
+	if num > ls.max {
+		ls.max = num
+		for k, n := range ls.seen {
+			if n+logSeenDepth < num {
+				delete(ls.seen, k)
+			}
+		}
+	}
+	return true
+}
+
+func (c *Client) wsLogs(ctx context.Context, wsurl string, params any, ls *logSeen, fn func(eth.Log)) error {
+	wsc, err := c.wsSubscribe(ctx, wsurl, "logs", params)
+	if err != nil {
+		return err
+	}
+	defer wsc.CloseNow()
+	defer c.wsKeepalive(ctx, wsc)()
+	for {
+		res := struct {
+			P struct {
+				R logResult `json:"result"`
+			} `json:"params"`
+		}{}
+		if err := wsjson.Read(ctx, wsc, &res); err != nil {
+			return fmt.Errorf("ws read %q: %w", wsurl, err)
+		}
+		r := res.P.R
+		if r.Log == nil || r.Removed {
+			continue
+		}
+		if !ls.add(uint64(r.BlockNum), r.BlockHash, uint64(r.Idx)) {
+			continue
+		}
+		fn(*r.Log)
+	}
+}
+
+// Pings wsc in the background until the returned func is
+// called. A ping that isn't answered within the ping
+// interval closes wsc which fails the caller's read.
+// Pongs are only processed while the caller is reading.
+// The returned func waits for the pinger to exit so that
+// it doesn't close wsc concurrently with the caller.
+func (c *Client) wsKeepalive(ctx context.Context, wsc *websocket.Conn) func() {
+	if c.wsPing <= 0 {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := c.clock.NewTicker(c.wsPing)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C():
+			}
+			pctx, pcancel := context.WithTimeout(ctx, c.wsPing)
+			err := wsc.Ping(pctx)
+			pcancel()
+			if err != nil {
+				if ctx.Err() == nil {
+					c.logger().DebugContext(ctx, "ws ping", "error", err)
+				}
+				wsc.CloseNow()
+				return
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// Returns the number of heads received before
+// the connection was closed or failed.
+func (c *Client) wsListen(ctx context.Context, wsurl string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	wsc, _, err := websocket.Dial(ctx, wsurl, nil)
+	if err != nil {
+		return 0, fmt.Errorf("ws dial %q: %w", wsurl, err)
+	}
+	defer wsc.CloseNow()
+	defer c.wsKeepalive(ctx, wsc)()
+	err = wsjson.Write(ctx, wsc, request{
+		ID:      "1",
+		Version: c.version,
+		Method:  "eth_subscribe",
+		Params:  []any{"newHeads"},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("ws write %q: %w", wsurl, err)
+	}
+	res := struct {
+		Error Error `json:"error"`
+		P     struct {
+			R NumHash `json:"result"`
+		} `json:"params"`
+	}{}
+	for n := 0; ; n++ {
+		if err := wsjson.Read(ctx, wsc, &res); err != nil {
+			return n, fmt.Errorf("ws read %q: %w", wsurl, err)
+		}
+		c.logger().DebugContext(ctx, "websocket newHeads",
+			"n", res.P.R.Num,
+			"h", fmt.Sprintf("%.4x", res.P.R.Hash),
+		)
+		c.lcache.update(res.P.R.Num, res.P.R.Hash, c.clock.Now())
+	}
+}
+
+// Time source for the caches and the latest poller
+type Clock interface {
+	Now() time.Time
+	NewTicker(time.Duration) Ticker
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Reset(time.Duration)
+	Stop()
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// Each poll must complete within this many poll intervals
+const pollTimeoutFactor = 3
+
+// Polls until ctx is done or an error occurs.
+func (c *Client) httpPoll(ctx context.Context, url string) error {
+	var (
+		interval = c.pollDuration
+		ticker   = c.clock.NewTicker(interval)
+		hresp    = headerResp{}
+
+		lastNum    uint64
+		lastChange time.Time
+	)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+		}
+		// A hung poll is abandoned before it can
+		// hold up too many subsequent ticks.
+		pctx, cancel := context.WithTimeout(ctx, pollTimeoutFactor*interval)
+		err := c.do(pctx, url, &hresp, request{
+			ID:      "1",
+			Version: c.version,
+			Method:  "eth_getBlockByNumber",
+			Params:  []any{"latest", false},
+		})
+		cancel()
+		if err != nil && ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			c.logger().DebugContext(ctx, "http poll timeout", "timeout", pollTimeoutFactor*interval)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if hresp.Error.Exists() {
+			const tag = "eth_getBlockByNumber/latest"
+			return fmt.Errorf("rpc=%s %w", tag, hresp.Error)
+		}
+		c.logger().DebugContext(ctx, "http poll",
+			"n", hresp.Number,
+			"h", fmt.Sprintf("%.4x", hresp.Hash),
+		)
+		c.lcache.update(hresp.Number, hresp.Hash, c.clock.Now())
+		if c.pollMax == 0 {
+			continue
+		}
+		n, now := uint64(hresp.Number), c.clock.Now()
+		switch {
+		case lastNum == 0:
+			lastNum, lastChange = n, now
+		case n > lastNum:
+			bt := now.Sub(lastChange) / time.Duration(n-lastNum)
+			d := adaptPoll(bt, c.pollMin, c.pollMax)
+			c.logger().DebugContext(ctx, "adapt poll", "block-time", bt, "poll", d)
+			ticker.Reset(d)
+			interval = d
+			lastNum, lastChange = n, now
+		}
+	}
+}
+
+// Polls several times per block so that new blocks are
+// noticed soon after they are produced.
+func adaptPoll(blockTime, lo, hi time.Duration) time.Duration {
+	const pollsPerBlock = 4
+	return max(lo, min(hi, blockTime/pollsPerBlock))
+}
+
+// Returns the cached latest block number and hash without
+// making a request or counting as a cache read.
+// ok is false when nothing has been cached or when the
+// cached block is older than the max age (see
+// [Client.WithLatestMaxAge]).
+func (c *Client) CachedLatest() (uint64, []byte, bool) {
+	now := c.clock.Now()
+	c.lcache.Lock()
+	defer c.lcache.Unlock()
+	if c.lcache.Num == 0 {
+		return 0, nil, false
+	}
+	if c.lcache.maxAge > 0 && now.Sub(c.lcache.updated) > c.lcache.maxAge {
+		return 0, nil, false
+	}
+	return uint64(c.lcache.Num), slices.Clone(c.lcache.Hash), true
+}
+
+// Returns the latest block number/hash greater than n.
+// If n is lower than the cached block number,
+// returns the cached value; otherwise, fetches the
+// latest block. Caching is based on comparing n
+// with the cached block number, not on time/LRU methods.
+//
+// When n is 0, Latest always fetches the latest block
+// rather than using the cached value,
+// bypassing the caching mechanism.
+// When confirmations is non-zero, Latest reports the block
+// k confirmations behind the tip along with its hash.
+// An error is returned when k is larger than the tip.
+func (c *Client) Latest(ctx context.Context, url string, n uint64) (_ uint64, _ []byte, err error) {
+	defer c.wrap(&err, url)
+	k := c.confirmations
+	if k == 0 {
+		return c.latest(ctx, url, n)
+	}
+	if n > 0 {
+		n += k
+	}
+	tip, _, err := c.latest(ctx, url, n)
+	if err != nil {
+		return 0, nil, err
+	}
+	if k > tip {
+		return 0, nil, fmt.Errorf("confirmations %d exceeds tip %d", k, tip)
+	}
+	h, err := c.hash(ctx, url, tip-k)
+	if err != nil {
+		return 0, nil, err
+	}
+	return tip - k, h, nil
+}
+
+func (c *Client) latest(ctx context.Context, url string, n uint64) (uint64, []byte, error) {
+	// Without a cache there is nothing for a poller to fill
+	c.lcache.once.Do(func() {
+		if c.nocache {
+			return
+		}
+		switch {
+		case c.latestSource != "http" && len(c.wsurls) > 0:
+			c.logger().DebugContext(ctx, "jrpc2 ws listening", "n", len(c.wsurls))
+			for _, wsurl := range c.wsurls {
+				go c.listen(context.Background(), url, wsurl)
+			}
+		default:
+			if c.latestSource == "ws" {
+				c.logger().WarnContext(ctx, "jrpc2 ws latest source without ws url")
+			}
+			c.logger().DebugContext(ctx, "jrpc2 http polling")
+			go func() {
+				if err := c.httpPoll(context.Background(), url); err != nil {
+					c.lcache.error(err)
+				}
+			}()
+		}
+	})
+	if n, h, ok := c.lcache.get(c.nocache, ctx, n, c.clock.Now()); ok {
+		return n, h, nil
+	}
+	// Concurrent callers share a single in-flight request.
+	// The request outlives any one caller's ctx so that a
+	// canceled caller doesn't fail the others.
+	type latest struct {
+		n uint64
+		h []byte
+	}
+	ch := c.latestGroup.DoChan(url, func() (any, error) {
+		ctx := context.WithoutCancel(ctx)
+		if c.hc.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.hc.Timeout)
+			defer cancel()
+		}
+		hresp := headerResp{}
+		err := c.do(ctx, url, &hresp, request{
+			ID:      c.id("eth_getBlockByNumber", fmt.Sprintf("latest-%d-%x", n, randbytes())),
+			Version: c.version,
+			Method:  "eth_getBlockByNumber",
+			Params:  []any{"latest", false},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable request latest: %w", err)
+		}
+		if hresp.Error.Exists() {
+			const tag = "eth_getBlockByNumber/latest"
+			return nil, fmt.Errorf("rpc=%s %w", tag, hresp.Error)
+		}
+		c.logger().DebugContext(ctx, "http-get-latest",
+			"n", hresp.Number,
+			"h", fmt.Sprintf("%.4x", hresp.Hash),
+		)
+		c.lcache.update(hresp.Number, hresp.Hash, c.clock.Now())
+		return latest{uint64(hresp.Number), hresp.Hash}, nil
+	})
+	select {
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return 0, nil, res.Err
+		}
+		l := res.Val.(latest)
+		return l.n, slices.Clone(l.h), nil
+	}
+}
+
+// Fetches the latest block with its full transactions in a
+// single request and updates the cached latest block (see
+// [Client.CachedLatest]). Use it instead of Latest followed
+// by Block when the tip's contents are needed right away.
+// When confirmations is non-zero, returns the block k
+// confirmations behind the tip which takes a second request.
+func (c *Client) LatestBlock(ctx context.Context, url string) (_ *eth.Block, err error) {
+	defer c.wrap(&err, url)
+	if k := c.confirmations; k > 0 {
+		tip, _, err := c.latest(ctx, url, 0)
+		if err != nil {
+			return nil, err
+		}
+		if k > tip {
+			return nil, fmt.Errorf("confirmations %d exceeds tip %d", k, tip)
+		}
+		b, err := c.block(ctx, url, eth.EncodeUint64(tip-k))
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			return nil, &missingBlockError{tip - k}
+		}
+		return b, nil
+	}
+	b, err := c.block(ctx, url, "latest")
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, fmt.Errorf("block latest: %w", ErrNotFound)
+	}
+	c.lcache.update(b.Header.Number, b.Header.Hash, c.clock.Now())
+	return b, nil
+}
+
+func (c *Client) Hash(ctx context.Context, url string, n uint64) (_ []byte, err error) {
+	defer c.wrap(&err, url)
+	return c.hash(ctx, url, n)
+}
+
+// Fetches block n with its full transactions. Unlike Get,
+// the block is never cached and no filter is applied.
+// Returns ErrNotFound when the node doesn't have the block.
+func (c *Client) Block(ctx context.Context, url string, n uint64) (_ *eth.Block, err error) {
+	defer c.wrap(&err, url)
+	b, err := c.block(ctx, url, eth.EncodeUint64(n))
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, &missingBlockError{n}
+	}
+	if got := b.Num(); got != n {
+		return nil, fmt.Errorf("block: want %d got %d", n, got)
+	}
+	return b, nil
+}
+
+// Like Block but for a tag (see [Client.HashTag]).
+// Returns ErrNotFound when the node doesn't support the tag.
+func (c *Client) BlockTag(ctx context.Context, url, tag string) (_ *eth.Block, err error) {
+	defer c.wrap(&err, url)
+	if err := checkTag(tag); err != nil {
+		return nil, err
+	}
+	b, err := c.block(ctx, url, tag)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, fmt.Errorf("block %s: %w", tag, ErrNotFound)
+	}
+	return b, nil
+}
+
+// Returns nil when the node doesn't have the block
+func (c *Client) block(ctx context.Context, url, numOrTag string) (*eth.Block, error) {
+	var (
+		b    eth.Block
+		resp = blockResp{Block: &b}
+	)
+	err := c.do(ctx, url, &resp, request{
+		ID:      c.id("eth_getBlockByNumber", fmt.Sprintf("block-%s-%x", numOrTag, randbytes())),
+		Version: c.version,
+		Method:  "eth_getBlockByNumber",
+		Params:  []any{numOrTag, true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable request block: %w", err)
+	}
+	if resp.Error.Exists() {
+		const tag = "eth_getBlockByNumber/block"
+		return nil, fmt.Errorf("rpc=%s %w", tag, resp.Error)
+	}
+	if resp.Block == nil {
+		return nil, nil
+	}
+	return &b, nil
+}
+
+// Returns the node's current block number using eth_blockNumber.
+// Unlike Latest, the result is never cached.
+func (c *Client) BlockNumber(ctx context.Context, url string) (_ uint64, err error) {
+	defer c.wrap(&err, url)
+	return c.blockNumber(ctx, url)
+}
+
+func (c *Client) blockNumber(ctx context.Context, url string) (uint64, error) {
+	resp := struct {
+		Error  Error      `json:"error"`
+		Result eth.Uint64 `json:"result"`
+	}{}
+	err := c.do(ctx, url, &resp, request{
+		ID:      c.id("eth_blockNumber", fmt.Sprintf("blocknumber-%x", randbytes())),
+		Version: c.version,
+		Method:  "eth_blockNumber",
+		Params:  []any{},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable request block number: %w", err)
+	}
+	if resp.Error.Exists() {
+		const tag = "eth_blockNumber"
+		return 0, fmt.Errorf("rpc=%s %w", tag, resp.Error)
+	}
+	return uint64(resp.Result), nil
+}
+
+func checkTag(tag string) error {
+	switch tag {
+	case "earliest", "latest", "safe", "finalized", "pending":
+		return nil
+	default:
+		return fmt.Errorf("invalid block tag: %q", tag)
+	}
+}
+
+// Resolves tag (earliest, latest, safe, finalized, or pending)
+// to a block number and hash with a single request.
+// Returns ErrNotFound when the node doesn't support the tag.
+func (c *Client) HashTag(ctx context.Context, url, tag string) (_ uint64, _ []byte, err error) {
+	defer c.wrap(&err, url)
+	if err := checkTag(tag); err != nil {
+		return 0, nil, err
+	}
+	hresp := headerResp{}
+	err = c.do(ctx, url, &hresp, request{
+		ID:      c.id("eth_getBlockByNumber", fmt.Sprintf("hashtag-%s-%x", tag, randbytes())),
+		Version: c.version,
+		Method:  "eth_getBlockByNumber",
+		Params:  []any{tag, false},
+	})
+	if err != nil {
+		return 0, nil, fmt.Errorf("unable request %s hash: %w", tag, err)
+	}
+	if hresp.Error.Exists() {
+		const tag = "eth_getBlockByNumber/hashtag"
+		return 0, nil, fmt.Errorf("rpc=%s %w", tag, hresp.Error)
+	}
+	if hresp.Header == nil {
+		return 0, nil, fmt.Errorf("block %s: %w", tag, ErrNotFound)
+	}
+	return uint64(hresp.Number), hresp.Hash, nil
+}
+
+// Returns the hashes for blocks start to start+limit-1
+// using a single batch of header-only requests.
+func (c *Client) Hashes(ctx context.Context, url string, start, limit uint64) (_ [][]byte, err error) {
+	defer c.wrap(&err, url)
+	if err := c.checkLimit(limit); err != nil {
+		return nil, err
+	}
+	return c.hashes(ctx, url, start, limit)
+}
+
+func (c *Client) hashes(ctx context.Context, url string, start, limit uint64) ([][]byte, error) {
+	type hashResp struct {
+		ID string `json:"id"`
+		headerResp
+	}
+	var (
+		prefix = fmt.Sprintf("hashes-%d-%d-%x", start, limit, randbytes())
+		reqs   = make([]request, limit)
+		resps  = make([]hashResp, 0, limit)
+		ids    = make(map[string]uint64, limit)
+	)
+	for i := uint64(0); i < limit; i++ {
+		id := c.id("eth_getBlockByNumber", fmt.Sprintf("%s-%d", prefix, i))
+		ids[id] = i
+		reqs[i] = request{
+			ID:      id,
+			Version: c.version,
+			Method:  "eth_getBlockByNumber",
+			Params:  []any{eth.EncodeUint64(start + i), false},
+		}
+	}
+	if err := c.do(ctx, url, &resps, reqs); err != nil {
+		return nil, fmt.Errorf("requesting hashes: %w", err)
+	}
+	hashes := make([][]byte, limit)
+	for i := range resps {
+		if resps[i].Error.Exists() {
+			const tag = "eth_getBlockByNumber/hashes"
+			return nil, fmt.Errorf("rpc=%s %w", tag, resps[i].Error)
+		}
+		j, ok := ids[resps[i].ID]
+		if !ok {
+			return nil, fmt.Errorf("hashes unexpected id: %s", resps[i].ID)
+		}
+		if resps[i].Header == nil {
+			return nil, &missingBlockError{start + j}
+		}
+		hashes[j] = resps[i].Hash
+	}
+	for i := range hashes {
+		if hashes[i] == nil {
+			return nil, fmt.Errorf("hashes missing block %d", start+uint64(i))
+		}
+	}
+	return hashes, nil
+}
+
+// Returns the number of blocks, ending at knownNum, on the
+// caller's branch that are no longer canonical. Zero means
+// knownHash is still the canonical hash for knownNum.
+//
+// Canonical hashes are requested in batches (see [Client.Hashes])
+// walking backwards from knownNum and the caller's branch is
+// followed using eth_getBlockByHash until a common ancestor is found.
+// Blocks above the current tip count as reorged.
+// Returns ErrNotFound when the node no longer has a block
+// on the caller's branch.
+func (c *Client) ReorgDepth(ctx context.Context, url string, knownNum uint64, knownHash []byte) (_ int, err error) {
+	defer c.wrap(&err, url)
+	tip, err := c.blockNumber(ctx, url)
+	if err != nil {
+		return 0, fmt.Errorf("reorg depth tip: %w", err)
+	}
+	const batch = 16
+	var (
+		depth int
+		num   = knownNum
+		hash  = knownHash
+		lo    uint64 // canonical[i] is the hash of block lo+i
+		canon [][]byte
+	)
+	for {
+		if num <= tip {
+			if len(canon) == 0 || num < lo {
+				n := min(num+1, batch)
+				if c.maxLimit > 0 {
+					n = min(n, c.maxLimit)
+				}
+				lo = num + 1 - n
+				canon, err = c.hashes(ctx, url, lo, n)
+				if err != nil {
+					return 0, fmt.Errorf("reorg depth hashes: %w", err)
+				}
+			}
+			if bytes.Equal(canon[num-lo], hash) {
+				return depth, nil
+			}
+		}
+		if num == 0 {
+			return 0, fmt.Errorf("reorg depth: no common ancestor")
+		}
+		depth++
+		hash, err = c.parentHash(ctx, url, hash)
+		if err != nil {
+			return 0, fmt.Errorf("reorg depth block %d: %w", num, err)
+		}
+		num--
+	}
+}
+
+// Returns the parent hash of the block with the given hash
+// using eth_getBlockByHash. Nodes return blocks that are
+// no longer canonical for as long as they keep them.
+func (c *Client) parentHash(ctx context.Context, url string, hash []byte) ([]byte, error) {
+	hresp := headerResp{}
+	err := c.do(ctx, url, &hresp, request{
+		ID:      c.id("eth_getBlockByHash", fmt.Sprintf("parent-%x-%x", hash, randbytes())),
+		Version: c.version,
+		Method:  "eth_getBlockByHash",
+		Params:  []any{eth.EncodeHex(hash), false},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable request block by hash: %w", err)
+	}
+	if hresp.Error.Exists() {
+		const tag = "eth_getBlockByHash"
+		return nil, fmt.Errorf("rpc=%s %w", tag, hresp.Error)
+	}
+	if hresp.Header == nil {
+		return nil, fmt.Errorf("block %.4x: %w", hash, ErrNotFound)
+	}
+	return hresp.Parent, nil
+}
+
+func (c *Client) hash(ctx context.Context, url string, n uint64) ([]byte, error) {
+	hresp := headerResp{}
+	err := c.do(ctx, url, &hresp, request{
+		ID:      c.id("eth_getBlockByNumber", fmt.Sprintf("hash-%d-%x", n, randbytes())),
+		Version: c.version,
+		Method:  "eth_getBlockByNumber",
+		Params:  []any{"0x" + strconv.FormatUint(n, 16), false},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable request hash: %w", err)
+	}
+	if hresp.Error.Exists() {
+		const tag = "eth_getBlockByNumber/hash"
+		return nil, fmt.Errorf("rpc=%s %w", tag, hresp.Error)
+	}
+	if hresp.Header == nil {
+		return nil, &missingBlockError{n}
+	}
+	return hresp.Hash, nil
+}
+
+type fingerprint struct {
+	chainID uint64
+	genesis []byte
+}
+
+func (fp fingerprint) equal(other fingerprint) bool {
+	return fp.chainID == other.chainID && bytes.Equal(fp.genesis, other.genesis)
+}
+
+func (fp fingerprint) String() string {
+	return fmt.Sprintf("chain=%d genesis=%.4x", fp.chainID, fp.genesis)
+}
+
+// Sends eth_chainId to each configured url (including
+// archive urls) concurrently. The returned error names
+// every url that is unreachable or returned an error.
+func (c *Client) Ping(ctx context.Context) (err error) {
+	defer c.wrap(&err, "")
+	if len(c.urls) == 0 {
+		return ErrNoURLs
+	}
+	var (
+		urls = append(slices.Clone(c.urls), c.archiveURLs...)
+		errs = make([]error, len(urls))
+		wg   sync.WaitGroup
+	)
+	for i := range urls {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := c.ping(ctx, urls[i].String()); err != nil {
+				errs[i] = fmt.Errorf("ping %s: %w", urls[i].Hostname(), err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (c *Client) ping(ctx context.Context, url string) error {
+	resp := struct {
+		Error  Error      `json:"error"`
+		Result eth.Uint64 `json:"result"`
+	}{}
+	err := c.do(ctx, url, &resp, request{
+		ID:      c.id("eth_chainId", fmt.Sprintf("ping-%x", randbytes())),
+		Version: c.version,
+		Method:  "eth_chainId",
+		Params:  []any{},
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Error.Exists() {
+		return fmt.Errorf("rpc=eth_chainId %w", resp.Error)
+	}
+	return nil
+}
+
+func (c *Client) fingerprint(ctx context.Context, url string) (fingerprint, error) {
+	var (
+		cresp = struct {
+			Error  Error      `json:"error"`
+			Result eth.Uint64 `json:"result"`
+		}{}
+		hresp = headerResp{}
+		resp  = []any{&cresp, &hresp}
+	)
+	err := c.do(ctx, url, &resp, []request{
+		request{
+			ID:      c.id("eth_chainId", fmt.Sprintf("chainid-%x", randbytes())),
+			Version: c.version,
+			Method:  "eth_chainId",
+			Params:  []any{},
+		},
+		request{
+			ID:      c.id("eth_getBlockByNumber", fmt.Sprintf("genesis-%x", randbytes())),
+			Version: c.version,
+			Method:  "eth_getBlockByNumber",
+			Params:  []any{"0x0", false},
+		},
+	})
+	switch {
+	case err != nil:
+		return fingerprint{}, fmt.Errorf("requesting fingerprint: %w", err)
+	case cresp.Error.Exists():
+		return fingerprint{}, fmt.Errorf("rpc=eth_chainId %w", cresp.Error)
+	case hresp.Error.Exists():
+		return fingerprint{}, fmt.Errorf("rpc=eth_getBlockByNumber/genesis %w", hresp.Error)
+	case hresp.Header == nil:
+		return fingerprint{}, fmt.Errorf("missing genesis block")
+	}
+	return fingerprint{uint64(cresp.Result), hresp.Hash}, nil
+}
+
+// Fetches the chain id and genesis hash for each URL
+// and compares them with the values first seen for that URL
+// and with the other URLs. An error indicates that a URL
+// is serving a different network than expected
+// (eg a misconfigured load balancer).
+func (c *Client) VerifyNetwork(ctx context.Context) (err error) {
+	defer c.wrap(&err, "")
+	if len(c.urls) == 0 {
+		return ErrNoURLs
+	}
+	var first *fingerprint
+	for _, u := range c.urls {
+		fp, err := c.fingerprint(ctx, u.String())
+		if err != nil {
+			return fmt.Errorf("fingerprinting %s: %w", u.Hostname(), err)
+		}
+		c.fpMut.Lock()
+		if c.fps == nil {
+			c.fps = make(map[string]fingerprint)
+		}
+		seen, ok := c.fps[u.String()]
+		if !ok {
+			c.fps[u.String()] = fp
+			seen = fp
+		}
+		c.fpMut.Unlock()
+		if !seen.equal(fp) {
+			const tag = "%s network changed. first: %s now: %s"
+			return fmt.Errorf(tag, u.Hostname(), seen, fp)
+		}
+		if first == nil {
+			first = &fp
+			continue
+		}
+		if !first.equal(fp) {
+			const tag = "%s network mismatch. want: %s got: %s"
+			return fmt.Errorf(tag, u.Hostname(), first, fp)
+		}
+	}
+	return nil
+}
+
+// Returns the node's suggested priority fee.
+// Older nodes don't support eth_maxPriorityFeePerGas
+// and return an error matching ErrMethodNotFound.
+func (c *Client) MaxPriorityFeePerGas(ctx context.Context, url string) (_ *uint256.Int, err error) {
+	defer c.wrap(&err, url)
+	resp := struct {
+		Error  Error       `json:"error"`
+		Result uint256.Int `json:"result"`
+	}{}
+	err = c.do(ctx, url, &resp, request{
+		ID:      c.id("eth_maxPriorityFeePerGas", fmt.Sprintf("priorityfee-%x", randbytes())),
+		Version: c.version,
+		Method:  "eth_maxPriorityFeePerGas",
+		Params:  []any{},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable request priority fee: %w", err)
+	}
+	if resp.Error.Exists() {
+		const tag = "eth_maxPriorityFeePerGas"
+		return nil, fmt.Errorf("rpc=%s %w", tag, resp.Error)
+	}
+	return &resp.Result, nil
+}
+
+// Batches one eth_call per msg at block n into a single
+// request. Results are returned in the same order as calls.
+func (c *Client) CallContracts(ctx context.Context, url string, calls []eth.CallMsg, n uint64) (_ [][]byte, err error) {
+	defer c.wrap(&err, url)
+	if len(calls) == 0 {
+		return nil, nil
+	}
+	type callResp struct {
+		ID     string    `json:"id"`
+		Error  Error     `json:"error"`
+		Result eth.Bytes `json:"result"`
+	}
+	var (
+		prefix = fmt.Sprintf("call-%d-%x", n, randbytes())
+		reqs   = make([]request, len(calls))
+		resps  = make([]callResp, 0, len(calls))
+		ids    = make(map[string]int, len(calls))
+	)
+	for i := range calls {
+		id := c.id("eth_call", fmt.Sprintf("%s-%d", prefix, i))
+		ids[id] = i
+		reqs[i] = request{
+			ID:      id,
+			Version: c.version,
+			Method:  "eth_call",
+			Params:  []any{calls[i], eth.EncodeUint64(n)},
+		}
+	}
+	if err := c.do(ctx, url, &resps, reqs); err != nil {
+		return nil, fmt.Errorf("requesting eth_call: %w", err)
+	}
+	res := make([][]byte, len(calls))
+	found := make([]bool, len(calls))
+	for i := range resps {
+		if resps[i].Error.Exists() {
+			return nil, fmt.Errorf("rpc=eth_call %w", resps[i].Error)
+		}
+		j, ok := ids[resps[i].ID]
+		if !ok {
+			return nil, fmt.Errorf("eth_call unexpected id: %s", resps[i].ID)
+		}
+		res[j] = resps[i].Result
+		found[j] = true
+	}
+	for i := range found {
+		if !found[i] {
+			return nil, fmt.Errorf("eth_call missing result for call %d", i)
+		}
+	}
+	return res, nil
+}
+
+// Replaces the generated request ids with f's ids. Since
+// batch responses are matched to requests by id, f must
+// return a unique id for each call. Websocket subscriptions
+// keep their ids. Requests split across batches call f
+// concurrently, so f must be safe for concurrent use.
+func (c *Client) WithIDFunc(f func(method string) string) *Client {
+	c.idFunc = f
+	return c
+}
+
+// Returns def unless an id func was set (see [Client.WithIDFunc])
+func (c *Client) id(method, def string) string {
+	if c.idFunc == nil {
+		return def
+	}
+	return c.idFunc(method)
+}
+
+// Sets the Multicall3 contract used by [Client.Multicall3].
+// Defaults to [eth.Multicall3Address].
+func (c *Client) WithMulticall3Address(addr []byte) *Client {
+	c.multicallAddr = addr
+	return c
+}
+
+// Aggregates calls into a single eth_call to the Multicall3
+// contract's aggregate3 function at block n. Results are
+// returned in the same order as calls. A call that fails
+// with AllowFailure set has a result with Success false.
+func (c *Client) Multicall3(ctx context.Context, url string, calls []eth.Multicall3Call, n uint64) (_ []eth.Multicall3Result, err error) {
+	defer c.wrap(&err, url)
+	if len(calls) == 0 {
+		return nil, nil
+	}
+	addr := c.multicallAddr
+	if len(addr) == 0 {
+		addr = eth.Multicall3Address
+	}
+	resp := struct {
+		Error  Error     `json:"error"`
+		Result eth.Bytes `json:"result"`
+	}{}
+	err = c.do(ctx, url, &resp, request{
+		ID:      c.id("eth_call", fmt.Sprintf("multicall-%d-%x", n, randbytes())),
+		Version: c.version,
+		Method:  "eth_call",
+		Params: []any{
+			eth.CallMsg{To: addr, Data: eth.EncodeAggregate3(calls)},
+			eth.EncodeUint64(n),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("requesting multicall: %w", err)
+	}
+	if resp.Error.Exists() {
+		const tag = "eth_call/multicall"
+		return nil, fmt.Errorf("rpc=%s %w", tag, resp.Error)
+	}
+	res, err := eth.DecodeAggregate3(resp.Result)
+	if err != nil {
+		return nil, fmt.Errorf("multicall: %w", err)
+	}
+	if len(res) != len(calls) {
+		const tag = "multicall: want %d results got %d"
+		return nil, fmt.Errorf(tag, len(calls), len(res))
+	}
+	return res, nil
+}
+
+// Fetches the index'th uncle (ommer) header of block blockNum.
+// Returns ErrNotFound when the block doesn't have the uncle.
+// Blocks list their uncles' hashes in [eth.Header.Uncles].
+func (c *Client) Uncle(ctx context.Context, url string, blockNum, index uint64) (_ eth.Header, err error) {
+	defer c.wrap(&err, url)
+	resp := headerResp{}
+	err = c.do(ctx, url, &resp, request{
+		ID:      c.id("eth_getUncleByBlockNumberAndIndex", fmt.Sprintf("uncle-%d-%d-%x", blockNum, index, randbytes())),
+		Version: c.version,
+		Method:  "eth_getUncleByBlockNumberAndIndex",
+		Params:  []any{eth.EncodeUint64(blockNum), eth.EncodeUint64(index)},
+	})
+	if err != nil {
+		return eth.Header{}, fmt.Errorf("unable request uncle: %w", err)
+	}
+	if resp.Error.Exists() {
+		const tag = "eth_getUncleByBlockNumberAndIndex"
+		return eth.Header{}, fmt.Errorf("rpc=%s %w", tag, resp.Error)
+	}
+	if resp.Header == nil {
+		return eth.Header{}, fmt.Errorf("uncle %d of block %d: %w", index, blockNum, ErrNotFound)
+	}
+	return *resp.Header, nil
+}
+
+// Returns ErrNotFound when the node doesn't know the tx
+func (c *Client) TxByHash(ctx context.Context, url string, hash []byte) (_ *eth.Tx, err error) {
+	defer c.wrap(&err, url)
+	resp := struct {
+		Error  Error   `json:"error"`
+		Result *eth.Tx `json:"result"`
+	}{}
+	err = c.do(ctx, url, &resp, request{
+		ID:      c.id("eth_getTransactionByHash", fmt.Sprintf("tx-%x", randbytes())),
+		Version: c.version,
+		Method:  "eth_getTransactionByHash",
+		Params:  []any{eth.EncodeHex(hash)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable request tx: %w", err)
+	}
+	if resp.Error.Exists() {
+		const tag = "eth_getTransactionByHash"
+		return nil, fmt.Errorf("rpc=%s %w", tag, resp.Error)
+	}
+	if resp.Result == nil {
+		return nil, fmt.Errorf("tx %.4x: %w", hash, ErrNotFound)
+	}
+	return resp.Result, nil
+}
+
+// Returns ErrNotFound when the node doesn't have a receipt
+// for the tx. For example, when the tx is still pending.
+func (c *Client) ReceiptByHash(ctx context.Context, url string, hash []byte) (_ *eth.Receipt, err error) {
+	defer c.wrap(&err, url)
+	resp := struct {
+		Error  Error          `json:"error"`
+		Result *receiptResult `json:"result"`
+	}{}
+	err = c.do(ctx, url, &resp, request{
+		ID:      c.id("eth_getTransactionReceipt", fmt.Sprintf("receipt-%x", randbytes())),
+		Version: c.version,
+		Method:  "eth_getTransactionReceipt",
+		Params:  []any{eth.EncodeHex(hash)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable request receipt: %w", err)
+	}
+	if resp.Error.Exists() {
+		const tag = "eth_getTransactionReceipt"
+		return nil, fmt.Errorf("rpc=%s %w", tag, resp.Error)
+	}
+	if resp.Result == nil {
+		return nil, fmt.Errorf("receipt %.4x: %w", hash, ErrNotFound)
+	}
+	r := &eth.Receipt{}
+	resp.Result.copy(r)
+	return r, nil
+}
+
+// Fetches the receipts for the block identified by hash
+// rather than number so that the receipts belong to a
+// specific fork. The returned block's txs contain only
+// the fields that are present in the receipts. Returns
+// ErrNotFound when the node doesn't know the block.
+func (c *Client) ReceiptsByBlockHash(ctx context.Context, url string, hash []byte) (_ *eth.Block, err error) {
+	defer c.wrap(&err, url)
+	resp := receiptResp{}
+	err = c.do(ctx, url, &resp, request{
+		ID:      c.id("eth_getBlockReceipts", fmt.Sprintf("receipts-hash-%x", randbytes())),
+		Version: c.version,
+		Method:  "eth_getBlockReceipts",
+		Params:  []any{map[string]string{"blockHash": eth.EncodeHex(hash)}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable request receipts: %w", err)
+	}
+	if resp.Error.Exists() {
+		const tag = "eth_getBlockReceipts/hash"
+		return nil, fmt.Errorf("rpc=%s %w", tag, resp.Error)
+	}
+	if resp.Result == nil {
+		return nil, fmt.Errorf("block %.4x: %w", hash, ErrNotFound)
+	}
+	b := &eth.Block{}
+	b.Header.Hash.Write(hash)
+	if len(resp.Result) == 0 {
+		return b, nil
+	}
+	for i := range resp.Result {
+		if got := resp.Result[i].BlockHash; !bytes.Equal(got, hash) {
+			const tag = "receipt %d: want block %.4x got %.4x"
+			return nil, fmt.Errorf(tag, resp.Result[i].TxIdx, hash, got)
+		}
+	}
+	num := uint64(resp.Result[0].BlockNum)
+	b.SetNum(num)
+	if _, err := c.addReceipts(ctx, blockmap{num: b}, num, 1, &resp); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Returns the account and storage proofs for addr
+// and slots at block n using eth_getProof.
+func (c *Client) Proof(ctx context.Context, url string, addr []byte, slots [][]byte, n uint64) (_ *eth.AccountProof, err error) {
+	defer c.wrap(&err, url)
+	keys := make([]string, len(slots))
+	for i := range slots {
+		keys[i] = eth.EncodeHex(slots[i])
+	}
+	resp := struct {
+		Error  Error             `json:"error"`
+		Result *eth.AccountProof `json:"result"`
+	}{}
+	err = c.do(ctx, url, &resp, request{
+		ID:      c.id("eth_getProof", fmt.Sprintf("proof-%d-%x", n, randbytes())),
+		Version: c.version,
+		Method:  "eth_getProof",
+		Params:  []any{eth.EncodeHex(addr), keys, eth.EncodeUint64(n)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable request proof: %w", err)
+	}
+	if resp.Error.Exists() {
+		const tag = "eth_getProof"
+		return nil, fmt.Errorf("rpc=%s %w", tag, resp.Error)
+	}
+	if resp.Result == nil {
+		return nil, fmt.Errorf("eth_getProof: no result for block %d", n)
+	}
+	return resp.Result, nil
 }
 
 type key struct {
 	a, b uint64
 }
 
-type blockmap map[uint64]*eth.Block
+type blockmap map[uint64]*eth.Block
+
+// Returns an archive URL when archive URLs are configured
+// and start is more than archiveDepth blocks below the
+// cached latest block. Otherwise returns url.
+func (c *Client) route(url string, start uint64) string {
+	if len(c.archiveURLs) == 0 {
+		return url
+	}
+	c.lcache.Lock()
+	latest := uint64(c.lcache.Num)
+	c.lcache.Unlock()
+	if latest == 0 || start+c.archiveDepth >= latest {
+		return url
+	}
+	n := atomic.AddUint64(&c.archiveCounter, 1)
+	return c.archiveURLs[n%uint64(len(c.archiveURLs))].String()
+}
+
+// Returns the methods that Get uses for filter
+func (c *Client) filterMethods(f *glf.Filter) []string {
+	var res []string
+	if f.UseBlocks || f.UseHeaders {
+		res = append(res, "eth_getBlockByNumber")
+	}
+	switch {
+	case f.UseReceipts:
+		res = append(res, "eth_getBlockReceipts")
+	case f.UseLogs:
+		// the logs batch includes a header for validation
+		res = append(res, "eth_getLogs", "eth_getBlockByNumber")
+	case f.UseTraces:
+		switch c.traceMethod {
+		case "trace_replayBlockTransactions":
+			res = append(res, c.traceMethod)
+		default:
+			res = append(res, "trace_block")
+		}
+	}
+	return res
+}
+
+func (c *Client) supports(url string, methods []string) bool {
+	supported, ok := c.methodSupport[url]
+	if !ok {
+		return true
+	}
+	for _, m := range methods {
+		if !slices.Contains(supported, m) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns url if it supports the methods needed by
+// filter. Otherwise returns the first configured url
+// (primary urls followed by archive urls) that does.
+func (c *Client) routeMethods(url string, filter *glf.Filter) (string, error) {
+	methods := c.filterMethods(filter)
+	if c.supports(url, methods) {
+		return url, nil
+	}
+	for _, u := range append(slices.Clone(c.urls), c.archiveURLs...) {
+		if c.supports(u.String(), methods) {
+			return u.String(), nil
+		}
+	}
+	return url, fmt.Errorf("no url supports %s", strings.Join(methods, ","))
+}
+
+// identifies the data requested by a filter
+func filterKey(f *glf.Filter) string {
+	return fmt.Sprintf("%s/%v/%v/%v", f, f.Addresses(), f.Topics(), newProjection(f))
+}
+
+// Results are cached by start, limit, and filter so that
+// identical requests share a single fetch and requests
+// with different filters never share (and mutate) blocks.
+func (c *Client) Get(
+	ctx context.Context,
+	url string,
+	filter *glf.Filter,
+	start, limit uint64,
+) (_ []eth.Block, err error) {
+	url, rerr := c.routeMethods(c.route(url, start), filter)
+	defer c.wrap(&err, url)
+	if rerr != nil {
+		return nil, rerr
+	}
+	if err := c.checkLimit(limit); err != nil {
+		return nil, err
+	}
+	t0 := time.Now()
+	defer func() {
+		c.logger().DebugContext(ctx,
+			"jrpc2-get",
+			"filter", filter,
+			"elapsed", time.Since(t0),
+		)
+	}()
+	if !c.nocache {
+		tip, _, _ := c.CachedLatest()
+		if n, ok := c.negcache.get(start, limit, tip, c.clock.Now()); ok {
+			return nil, &missingBlockError{n}
+		}
+	}
+	defer func() {
+		mb := &missingBlockError{}
+		if errors.As(err, &mb) {
+			c.negcache.set(mb.Num, c.clock.Now().Add(c.pollDuration))
+		}
+	}()
+	k := segkey{start, limit, filterKey(filter)}
+	return c.cache.get(c.nocache, ctx, url, k, func(ctx context.Context, url string, start, limit uint64) ([]eth.Block, error) {
+		return c.stored(ctx, url, filter, start, limit)
+	})
+}
+
+// Stores blocks that will never change. Since Get results
+// depend on the filter, blocks are keyed by the filter and
+// the block number. Implementations must be safe for
+// concurrent use and may retain the blocks passed to Put.
+// Blocks returned by Load are shared with the caller.
+type BlockStore interface {
+	Load(key string, n uint64) (*eth.Block, bool)
+	Put(key string, n uint64, b *eth.Block)
+}
+
+// An in-memory [BlockStore] without eviction
+type MemBlockStore struct {
+	sync.Mutex
+	blocks map[segkey]*eth.Block
+}
+
+func (s *MemBlockStore) Load(key string, n uint64) (*eth.Block, bool) {
+	s.Lock()
+	defer s.Unlock()
+	b, ok := s.blocks[segkey{start: n, filter: key}]
+	return b, ok
+}
+
+func (s *MemBlockStore) Put(key string, n uint64, b *eth.Block) {
+	s.Lock()
+	defer s.Unlock()
+	if s.blocks == nil {
+		s.blocks = make(map[segkey]*eth.Block)
+	}
+	s.blocks[segkey{start: n, filter: key}] = b
+}
+
+const (
+	initialBatchSize = 10
+	maxBatchSize     = 1000
+)
+
+// A batchSizer per filter key (see filterKey)
+type batchSizers struct {
+	sync.Mutex
+	target int64
+	sizers map[string]*batchSizer
+}
+
+func (bss *batchSizers) get(key string) *batchSizer {
+	bss.Lock()
+	defer bss.Unlock()
+	if bss.sizers == nil {
+		bss.sizers = make(map[string]*batchSizer)
+	}
+	bs, ok := bss.sizers[key]
+	if !ok {
+		bs = &batchSizer{target: bss.target}
+		bss.sizers[key] = bs
+	}
+	return bs
+}
+
+type batchSizer struct {
+	sync.Mutex
+	target int64
+	size   uint64
+}
+
+func (bs *batchSizer) get(maxLimit uint64) uint64 {
+	bs.Lock()
+	defer bs.Unlock()
+	if bs.size == 0 {
+		bs.size = initialBatchSize
+	}
+	if maxLimit > 0 {
+		return min(bs.size, maxLimit)
+	}
+	return bs.size
+}
+
+// Sets the size to the number of blocks that would fill
+// the target given that n blocks had a response of
+// size bytes. The size changes by at most a factor of 2
+// so that a single outlier doesn't swing it too far.
+func (bs *batchSizer) observe(n uint64, size int64) {
+	if n == 0 || size <= 0 {
+		return
+	}
+	bs.Lock()
+	defer bs.Unlock()
+	ideal := uint64(bs.target) * n / uint64(size)
+	bs.size = max(1, min(ideal, 2*bs.size, maxBatchSize), bs.size/2)
+}
+
+type finality struct {
+	sync.Mutex
+	num   uint64
+	exp   time.Time
+	group singleflight.Group
+}
+
+// Reports whether n is at or below the finalized head.
+// The finalized head is requested at most once per
+// poll duration and only when n is above the last one.
+// The request is made without holding the lock and
+// concurrent callers share a single request.
+func (c *Client) finalized(ctx context.Context, url string, n uint64) bool {
+	c.final.Lock()
+	if n <= c.final.num {
+		c.final.Unlock()
+		return true
+	}
+	now := c.clock.Now()
+	if now.Before(c.final.exp) {
+		c.final.Unlock()
+		return false
+	}
+	c.final.exp = now.Add(c.pollDuration)
+	c.final.Unlock()
+
+	res, err, _ := c.final.group.Do(url, func() (any, error) {
+		num, _, err := c.HashTag(ctx, url, "finalized")
+		return num, err
+	})
+	if err != nil {
+		c.logger().DebugContext(ctx, "finalized", "error", err)
+		return false
+	}
+	c.final.Lock()
+	defer c.final.Unlock()
+	c.final.num = max(c.final.num, res.(uint64))
+	return n <= c.final.num
+}
+
+// Reads finalized ranges from the block store when
+// every block in the range is present and otherwise
+// calls get and saves the blocks. The Txs are copied
+// to and from the store so that changes to the returned
+// blocks don't change the stored ones. Ranges that may have
+// skipped logs (see [Client.WithSkipUnfetchableLogs])
+// aren't stored.
+func (c *Client) stored(
+	ctx context.Context,
+	url string,
+	filter *glf.Filter,
+	start, limit uint64,
+) ([]eth.Block, error) {
+	switch {
+	case c.store == nil, limit == 0, c.skipLogs && filter.UseLogs:
+		return c.get(ctx, url, filter, start, limit)
+	case !c.finalized(ctx, url, start+limit-1):
+		return c.get(ctx, url, filter, start, limit)
+	}
+	var (
+		k      = filterKey(filter)
+		blocks = make([]eth.Block, limit)
+		hit    = true
+	)
+	for i := uint64(0); i < limit; i++ {
+		b, ok := c.store.Load(k, start+i)
+		if !ok {
+			hit = false
+			break
+		}
+		blocks[i].Header = b.Header
+		blocks[i].Txs = slices.Clone(b.Txs)
+	}
+	if hit {
+		return blocks, nil
+	}
+	// Blocks before a *PartialError are complete
+	// and are stored and returned with the error.
+	blocks, err := c.get(ctx, url, filter, start, limit)
+	pe := &PartialError{}
+	if err != nil && !errors.As(err, &pe) {
+		return nil, err
+	}
+	for i := range blocks {
+		c.store.Put(k, blocks[i].Num(), &eth.Block{
+			Header: blocks[i].Header,
+			Txs:    slices.Clone(blocks[i].Txs),
+		})
+	}
+	return blocks, err
+}
+
+// Splits the range into batches sized by the batch sizer
+// (see [Client.WithTargetBatchBytes]) and by the ctx's
+// budget (see [WithBudget]), checking that each batch
+// builds on the previous one. When the budget runs out,
+// the blocks so far are returned with a *PartialError.
+func (c *Client) get(
+	ctx context.Context,
+	url string,
+	filter *glf.Filter,
+	start, limit uint64,
+) ([]eth.Block, error) {
+	bud, _ := ctx.Value(budgetKey{}).(*budget)
+	if c.cost == nil {
+		bud = nil
+	}
+	if c.batch.target <= 0 && bud == nil {
+		return c.getBatch(ctx, url, filter, start, limit)
+	}
+	var (
+		blocks = make([]eth.Block, 0, limit)
+		prev   *eth.Block
+		sizer  *batchSizer
+	)
+	if c.batch.target > 0 {
+		sizer = c.batch.get(filterKey(filter))
+	}
+	for i := uint64(0); i < limit; {
+		var (
+			rs = &respSize{}
+			n  = limit - i
+		)
+		if sizer != nil {
+			n = min(sizer.get(c.maxLimit), n)
+		}
+		if bud != nil {
+			n = bud.reserve(n, func(n uint64) int {
+				return c.batchCost(filter, n)
+			})
+			if n == 0 && len(blocks) == 0 {
+				return nil, ErrOverBudget
+			}
+			if n == 0 {
+				return blocks, &PartialError{Num: start + i, Err: ErrOverBudget}
+			}
+		}
+		batch, err := c.getBatch(context.WithValue(ctx, respSizeKey{}, rs), url, filter, start+i, n)
+		if sizer != nil {
+			sizer.observe(n, rs.max)
+		}
+		pe := &PartialError{}
+		if err != nil && !errors.As(err, &pe) {
+			return nil, err
+		}
+		for j := range batch {
+			if err := c.checkLink(url, prev, &batch[j]); err != nil {
+				return nil, err
+			}
+			blocks = blocks[:len(blocks)+1]
+			blocks[len(blocks)-1].Header = batch[j].Header
+			blocks[len(blocks)-1].Txs = batch[j].Txs
+			prev = &blocks[len(blocks)-1]
+		}
+		if err != nil {
+			return blocks, err
+		}
+		i += n
+	}
+	return blocks, nil
+}
+
+func (c *Client) getBatch(
+	ctx context.Context,
+	url string,
+	filter *glf.Filter,
+	start, limit uint64,
+) ([]eth.Block, error) {
+	var (
+		blocks []eth.Block
+		err    error
+	)
+	if c.combineBatches && c.streamf == nil &&
+		(filter.UseBlocks || filter.UseHeaders) &&
+		(filter.UseReceipts || filter.UseLogs) {
+		blocks, err = c.combined(ctx, url, filter, start, limit)
+		if err != nil || !filter.UseBlocks {
+			return blocks, err
+		}
+		return blocks, c.verifyTxRoots(ctx, url, blocks)
+	}
+	switch {
+	case filter.UseBlocks:
+		blocks, err = c.blocks(ctx, url, start, limit)
+		if err != nil {
+			return nil, fmt.Errorf("getting blocks: %w", err)
+		}
+		if err := c.verifyTxRoots(ctx, url, blocks); err != nil {
+			return nil, err
+		}
+	case filter.UseHeaders:
+		blocks, err = c.headers(ctx, url, start, limit)
+		if err != nil {
+			return nil, fmt.Errorf("getting headers: %w", err)
+		}
+	default:
+		for i := uint64(0); i < limit; i++ {
+			blocks = append(blocks, eth.Block{
+				Header: eth.Header{
+					Number: eth.Uint64(start + i),
+				},
+			})
+		}
+	}
+
+	bm := make(blockmap)
+	for i := range blocks {
+		bm[blocks[i].Num()] = &blocks[i]
+	}
+
+	switch {
+	case filter.UseReceipts:
+		if err := c.receipts(ctx, url, filter, bm, start, limit); err != nil {
+			return nil, fmt.Errorf("getting receipts: %w", err)
+		}
+	case filter.UseLogs:
+		err := c.logs(ctx, url, filter, bm, start, limit)
+		if err != nil && !c.skipLogsErr(ctx, start, limit, err) {
+			return nil, fmt.Errorf("getting logs: %w", err)
+		}
+	case filter.UseTraces:
+		n, err := c.traces(ctx, url, bm, start, limit)
+		if err != nil && c.partialTraces {
+			return blocks[:n], &PartialError{Num: start + n, Err: err}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("getting traces: %w", err)
+		}
+	}
+	return blocks, nil
+}
+
+// Requests blocks (or headers) along with their receipts
+// or logs in a single batch. See [Client.WithCombinedBatches].
+func (c *Client) combined(
+	ctx context.Context,
+	url string,
+	filter *glf.Filter,
+	start, limit uint64,
+) ([]eth.Block, error) {
+	var (
+		t0     = time.Now()
+		ids    = byID{}
+		reqs   []request
+		blocks []eth.Block
+		check  func() error
+		rresps []receiptResp
+		lresps []logResp
+	)
+	if filter.UseBlocks {
+		breqs, bresps, b := c.blocksReqs(start, limit)
+		for i := range breqs {
+			ids[breqs[i].ID] = &bresps[i]
+		}
+		reqs, blocks = append(reqs, breqs...), b
+		check = func() error { return checkBlocks(start, bresps) }
+	} else {
+		hreqs, hresps, b := c.headersReqs(start, limit)
+		for i := range hreqs {
+			ids[hreqs[i].ID] = &hresps[i]
+		}
+		reqs, blocks = append(reqs, hreqs...), b
+		check = func() error { return checkHeaders(start, hresps) }
+	}
+	switch {
+	case filter.UseReceipts:
+		rreqs := c.receiptsReqs(start, limit)
+		rresps = make([]receiptResp, limit)
+		for i := range rreqs {
+			ids[rreqs[i].ID] = &rresps[i]
+		}
+		reqs = append(reqs, rreqs...)
+	case filter.UseLogs:
+		groups := newLogFilter(filter, start, limit).split(c.logsAddrGroup)
+		lresps = make([]logResp, len(groups))
+		for i := range groups {
+			r := request{
+				ID:      c.id("eth_getLogs", fmt.Sprintf("logs-%d-%d-%x", start, limit, randbytes())),
+				Version: c.version,
+				Method:  "eth_getLogs",
+				Params:  []any{groups[i]},
+			}
+			ids[r.ID] = &lresps[i]
+			reqs = append(reqs, r)
+		}
+	}
+	if err := c.do(ctx, url, ids, reqs); err != nil {
+		return nil, fmt.Errorf("requesting combined batch: %w", err)
+	}
+	if err := check(); err != nil {
+		return nil, err
+	}
+	if err := c.validate("combined", start, limit, blocks); err != nil {
+		return nil, err
+	}
+	for i := range blocks {
+		c.discard(&blocks[i])
+	}
+	bm := make(blockmap)
+	for i := range blocks {
+		bm[blocks[i].Num()] = &blocks[i]
+	}
+	switch {
+	case filter.UseReceipts:
+		for i := range rresps {
+			for j := range rresps[i].Result {
+				r := &rresps[i].Result[j]
+				if err := checkHash(bm, uint64(r.BlockNum), r.BlockHash); err != nil {
+					return nil, fmt.Errorf("eth_getBlockReceipts: %w", err)
+				}
+			}
+		}
+		p := newProjection(filter)
+		for i := range rresps {
+			if err := c.verifyReceiptsRoot(bm, start+uint64(i), rresps[i].Result); err != nil {
+				return nil, err
+			}
+			p.apply(rresps[i].Result)
+		}
+		if err := c.addReceiptResps(ctx, bm, start, limit, rresps); err != nil {
+			return nil, fmt.Errorf("getting receipts: %w", err)
+		}
+	case filter.UseLogs:
+		results := make([][]logResult, len(lresps))
+		for i := range lresps {
+			if lresps[i].Error.Exists() {
+				return nil, fmt.Errorf("rpc=eth_getLogs %w", lresps[i].Error)
+			}
+			for j := range lresps[i].Result {
+				l := &lresps[i].Result[j]
+				if err := checkHash(bm, uint64(l.BlockNum), l.BlockHash); err != nil {
+					return nil, fmt.Errorf("eth_getLogs: %w", err)
+				}
+			}
+			results[i] = lresps[i].Result
+		}
+		if err := addLogs(bm, start, limit, nil, results); err != nil {
+			return nil, fmt.Errorf("getting logs: %w", err)
+		}
+	}
+	c.logger().DebugContext(ctx, "http-get-combined", "elapsed", time.Since(t0))
+	return blocks, nil
+}
+
+// A combined batch may be served by several backends
+// behind a load balancer. Data for a block must have
+// been read from the same version of the block.
+func checkHash(bm blockmap, num uint64, h []byte) error {
+	b, ok := bm[num]
+	if !ok || bytes.Equal(b.Header.Hash, h) {
+		return nil
+	}
+	return fmt.Errorf("block %d hash mismatch. want=%.4x got=%.4x", num, b.Header.Hash, h)
+}
+
+// Returned when the node responds with null for a block
+// that hasn't been mined. errors.Is(err, ErrNotFound) is true.
+type missingBlockError struct {
+	Num uint64
+}
+
+func (e *missingBlockError) Error() string {
+	return fmt.Sprintf("block %d: %s", e.Num, ErrNotFound)
+}
+
+func (e *missingBlockError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// Remembers the first unmined block seen so that a sync
+// loop racing the tip doesn't issue a request per call.
+// Entries expire after a short ttl or when the cached tip
+// reaches the missing block.
+type negcache struct {
+	sync.Mutex
+	num uint64
+	exp time.Time
+}
+
+func (nc *negcache) set(num uint64, exp time.Time) {
+	nc.Lock()
+	defer nc.Unlock()
+	nc.num, nc.exp = num, exp
+}
+
+func (nc *negcache) get(start, limit, tip uint64, now time.Time) (uint64, bool) {
+	nc.Lock()
+	defer nc.Unlock()
+	switch {
+	case nc.num == 0:
+		return 0, false
+	case now.After(nc.exp), tip >= nc.num:
+		nc.num = 0
+		return 0, false
+	case start+limit-1 < nc.num:
+		return 0, false
+	default:
+		return nc.num, true
+	}
+}
+
+// Returned by GetChecked when the first block in a
+// range doesn't build on the expected parent.
+type ReorgError struct {
+	Num  uint64
+	Want []byte
+	Got  []byte
+}
+
+func (e *ReorgError) Error() string {
+	const tag = "reorg at block %d. want parent: %.4x got: %.4x"
+	return fmt.Sprintf(tag, e.Num, e.Want, e.Got)
+}
+
+// Like Get but also checks that the first block's parent
+// is expectedParent. Returns a *ReorgError otherwise.
+// The filter must use headers or blocks so that
+// the parent hash is available.
+func (c *Client) GetChecked(
+	ctx context.Context,
+	url string,
+	filter *glf.Filter,
+	start, limit uint64,
+	expectedParent []byte,
+) (_ []eth.Block, err error) {
+	blocks, err := c.Get(ctx, url, filter, start, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer c.wrap(&err, url)
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("no blocks")
+	}
+	first := &blocks[0]
+	if len(first.Header.Parent) == 0 {
+		return nil, fmt.Errorf("missing parent hash. filter must use headers or blocks")
+	}
+	if !bytes.Equal(first.Header.Parent, expectedParent) {
+		return nil, &ReorgError{
+			Num:  first.Num(),
+			Want: expectedParent,
+			Got:  first.Header.Parent,
+		}
+	}
+	return blocks, nil
+}
+
+// Number of blocks requested at a time by GetStream
+const streamChunk = 10
+
+// Like Get but sends each block on the returned channel as
+// soon as the chunk containing it has been fetched and
+// validated. The next chunk is fetched while the current one
+// is being received. Consecutive blocks in different chunks
+// are checked for continuity. The block channel is closed when
+// the range is done or when an error (at most one) is sent on
+// the error channel. Canceling ctx stops the stream.
+func (c *Client) GetStream(
+	ctx context.Context,
+	url string,
+	filter *glf.Filter,
+	start, limit uint64,
+) (<-chan *eth.Block, <-chan error) {
+	type chunk struct {
+		blocks []eth.Block
+		err    error
+	}
+	var (
+		res    = make(chan *eth.Block)
+		errc   = make(chan error, 1)
+		chunks = make(chan chunk, 1)
+		n      = uint64(streamChunk)
+	)
+	if c.maxLimit > 0 {
+		n = min(n, c.maxLimit)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer close(chunks)
+		for i := uint64(0); i < limit; i += n {
+			blocks, err := c.Get(ctx, url, filter, start+i, min(n, limit-i))
+			select {
+			case chunks <- chunk{blocks, err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer cancel()
+		defer close(errc)
+		defer close(res)
+		var prev *eth.Block
+		for ch := range chunks {
+			if ch.err != nil {
+				errc <- ch.err
+				return
+			}
+			for i := range ch.blocks {
+				b := &ch.blocks[i]
+				if err := c.checkLink(url, prev, b); err != nil {
+					errc <- err
+					return
+				}
+				select {
+				case res <- b:
+				case <-ctx.Done():
+					errc <- ctx.Err()
+					return
+				}
+				prev = b
+			}
+		}
+	}()
+	return res, errc
+}
+
+// Checks that curr follows prev. Hashes are only
+// compared when both blocks have them.
+func (c *Client) checkLink(url string, prev, curr *eth.Block) (err error) {
+	if prev == nil {
+		return nil
+	}
+	defer c.wrap(&err, url)
+	if !c.allowGaps && curr.Num() != prev.Num()+1 {
+		const tag = "stream: want block %d got %d"
+		return fmt.Errorf(tag, prev.Num()+1, curr.Num())
+	}
+	if c.skipLinkage || len(prev.Hash()) == 0 || len(curr.Header.Parent) == 0 {
+		return nil
+	}
+	if !bytes.Equal(curr.Header.Parent, prev.Hash()) {
+		return &ReorgError{
+			Num:  curr.Num(),
+			Want: prev.Hash(),
+			Got:  curr.Header.Parent,
+		}
+	}
+	return nil
+}
+
+// Fetches the data for filter, start, limit in the
+// background so that a subsequent call to Get with the same
+// arguments is served from the cache. A Get that is issued while
+// the prefetch is in flight waits for it rather than
+// making a second request. Prefetch does nothing when
+// caching is disabled.
+func (c *Client) Prefetch(ctx context.Context, url string, filter *glf.Filter, start, limit uint64) {
+	if c.nocache {
+		return
+	}
+	k := segkey{start, limit, filterKey(filter)}
+	go c.cache.prefetch(ctx, url, k, func(ctx context.Context, url string, start, limit uint64) ([]eth.Block, error) {
+		return c.stored(ctx, url, filter, start, limit)
+	})
+}
+
+type blockResp struct {
+	Error      Error `json:"error"`
+	*eth.Block `json:"result"`
+}
+
+type segment struct {
+	sync.Mutex
+	nreads int
+	done   bool
+	d      []eth.Block
+}
+
+type segkey struct {
+	start, limit uint64
+	filter       string
+}
+
+type cache struct {
+	sync.Mutex
+	maxreads int
+	segments map[segkey]*segment
+
+	hits, misses  atomic.Uint64
+	waits, waitns atomic.Uint64
+	coalesced     atomic.Uint64
+}
+
+// Cumulative counters for a method or, for batches with
+// several methods, a comma separated list of methods.
+type MethodStats struct {
+	Requests uint64
+	BytesOut uint64
+	BytesIn  uint64
+}
+
+type methodCounters struct {
+	requests, out, in atomic.Uint64
+}
+
+func (mc *methodCounters) add(out, in uint64) {
+	mc.requests.Add(1)
+	mc.out.Add(out)
+	mc.in.Add(in)
+}
+
+func (c *Client) methodStats(m string) *methodCounters {
+	if mc, ok := c.methods.Load(m); ok {
+		return mc.(*methodCounters)
+	}
+	mc, _ := c.methods.LoadOrStore(m, &methodCounters{})
+	return mc.(*methodCounters)
+}
+
+// Counters for the client. See [Client.Stats].
+type Stats struct {
+	CacheHits   uint64
+	CacheMisses uint64
+
+	// Number of callers that blocked on a segment
+	// while another caller was fetching it and the
+	// total time spent waiting.
+	CacheWaits    uint64
+	CacheWaitTime time.Duration
+
+	// Number of waits that were satisfied by
+	// another caller's fetch.
+	CacheCoalesced uint64
+
+	// Keyed by method. See [MethodStats].
+	Methods map[string]MethodStats
+}
+
+func (c *Client) Stats() Stats {
+	ms := map[string]MethodStats{}
+	c.methods.Range(func(k, v any) bool {
+		mc := v.(*methodCounters)
+		ms[k.(string)] = MethodStats{
+			Requests: mc.requests.Load(),
+			BytesOut: mc.out.Load(),
+			BytesIn:  mc.in.Load(),
+		}
+		return true
+	})
+	return Stats{
+		CacheHits:      c.cache.hits.Load(),
+		CacheMisses:    c.cache.misses.Load(),
+		CacheWaits:     c.cache.waits.Load(),
+		CacheWaitTime:  time.Duration(c.cache.waitns.Load()),
+		CacheCoalesced: c.cache.coalesced.Load(),
+		Methods:        ms,
+	}
+}
+
+type getter func(ctx context.Context, url string, start, limit uint64) ([]eth.Block, error)
+
+func (c *cache) pruneMaxRead() {
+	for k, v := range c.segments {
+		// A locked segment is being fetched or read.
+		// Skip it rather than blocking all callers.
+		if !v.TryLock() {
+			continue
+		}
+		if v.nreads >= c.maxreads {
+			delete(c.segments, k)
+		}
+		v.Unlock()
+	}
+}
+
+// keeps the segments for the 5 highest start values.
+// there may be several segments (filters) per start.
+func (c *cache) pruneSegments() {
+	const size = 5
+	var starts []uint64
+	for k := range c.segments {
+		if !slices.Contains(starts, k.start) {
+			starts = append(starts, k.start)
+		}
+	}
+	if len(starts) <= size {
+		return
+	}
+	sort.Slice(starts, func(i, j int) bool {
+		return starts[i] > starts[j]
+	})
+	for k := range c.segments {
+		if k.start < starts[size-1] {
+			delete(c.segments, k)
+		}
+	}
+}
+
+// returns the segment for k. creating it if needed.
+func (c *cache) segment(k segkey) *segment {
+	c.Lock()
+	defer c.Unlock()
+	if c.segments == nil {
+		c.segments = make(map[segkey]*segment)
+	}
+	c.pruneMaxRead()
+	seg, ok := c.segments[k]
+	if !ok {
+		seg = &segment{}
+		c.segments[k] = seg
+	}
+	c.pruneSegments()
+	return seg
+}
+
+// Like get but doesn't count as a read of the segment
+// and doesn't return the blocks.
+func (c *cache) prefetch(ctx context.Context, url string, k segkey, f getter) {
+	seg := c.segment(k)
+	seg.Lock()
+	defer seg.Unlock()
+	if seg.done {
+		return
+	}
+	blocks, err := f(ctx, url, k.start, k.limit)
+	if err != nil {
+		slog.DebugContext(ctx, "prefetch", "start", k.start, "limit", k.limit, "error", err)
+		return
+	}
+	seg.d = blocks
+	seg.done = true
+}
+
+// Results from f are only cached when f doesn't return an error.
+// The blocks returned by f are returned with the error
+// to support partial results.
+func (c *cache) get(nocache bool, ctx context.Context, url string, k segkey, f getter) ([]eth.Block, error) {
+	if nocache {
+		return f(ctx, url, k.start, k.limit)
+	}
+	seg := c.segment(k)
+	contended := !seg.TryLock()
+	if contended {
+		c.waits.Add(1)
+		t0 := time.Now()
+		seg.Lock()
+		c.waitns.Add(uint64(time.Since(t0)))
+	}
+	defer seg.Unlock()
+	seg.nreads++
+	if seg.done {
+		c.hits.Add(1)
+		if contended {
+			c.coalesced.Add(1)
+		}
+		return seg.d, nil
+	}
+	c.misses.Add(1)
+
+	blocks, err := f(ctx, url, k.start, k.limit)
+	if err != nil {
+		return blocks, err
+	}
+
+	seg.d = blocks
+	seg.done = true
+	return seg.d, nil
+}
+
+func (c *Client) blocksReqs(start, limit uint64) ([]request, []blockResp, []eth.Block) {
+	var (
+		reqs   = make([]request, limit)
+		resps  = make([]blockResp, limit)
+		blocks = make([]eth.Block, limit)
+	)
+	for i := uint64(0); i < limit; i++ {
+		reqs[i] = request{
+			ID:      c.id("eth_getBlockByNumber", fmt.Sprintf("blocks-%d-%d-%x", start, limit, randbytes())),
+			Version: c.version,
+			Method:  "eth_getBlockByNumber",
+			Params:  []any{eth.EncodeUint64(start + i), true},
+		}
+		resps[i].Block = &blocks[i]
+	}
+	return reqs, resps, blocks
+}
+
+func checkBlocks(start uint64, resps []blockResp) error {
+	for i := range resps {
+		if resps[i].Error.Exists() {
+			const tag = "eth_getBlockByNumber"
+			return fmt.Errorf("rpc=%s %w", tag, resps[i].Error)
+		}
+		if resps[i].Block == nil {
+			return &missingBlockError{start + uint64(i)}
+		}
+	}
+	return nil
+}
+
+func (c *Client) blocks(ctx context.Context, url string, start, limit uint64) ([]eth.Block, error) {
+	var (
+		t0                  = time.Now()
+		reqs, resps, blocks = c.blocksReqs(start, limit)
+
+		dest any = &resps
+		i    int // number of streamed responses
+	)
+	if c.streamf != nil {
+		dest = stream(func(dec *json.Decoder) error {
+			if i >= len(resps) {
+				var discard json.RawMessage
+				return dec.Decode(&discard)
+			}
+			if err := dec.Decode(&resps[i]); err != nil {
+				return err
+			}
+			if !resps[i].Error.Exists() && resps[i].Block != nil {
+				c.discard(&blocks[i])
+				c.streamf(&blocks[i])
+			}
+			i++
+			return nil
+		})
+	}
+	err := c.do(ctx, url, dest, reqs)
+	if err != nil {
+		return nil, fmt.Errorf("requesting blocks: %w", err)
+	}
+	if c.streamf == nil {
+		i = len(resps)
+	}
+	if i < len(reqs) {
+		return nil, &ErrIncompleteBatch{Want: len(reqs), Got: i}
+	}
+	if err := checkBlocks(start, resps); err != nil {
+		return nil, err
+	}
+	for i := range blocks {
+		c.discard(&blocks[i])
+	}
+	c.logger().DebugContext(ctx, "http-get-blocks", "elapsed", time.Since(t0))
+	return blocks, c.validate("blocks", start, limit, blocks)
+}
+
+func (c *Client) validate(caller string, start, limit uint64, blocks []eth.Block) error {
+	if len(blocks) == 0 {
+		return fmt.Errorf("%s: no blocks", caller)
+	}
+	if !c.allowGaps {
+		if err := validateBounds(caller, start, limit, blocks); err != nil {
+			return err
+		}
+	}
+	if !c.skipLinkage {
+		if err := validateLinkage(caller, blocks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateBounds(caller string, start, limit uint64, blocks []eth.Block) error {
+	if len(blocks) == 0 {
+		return fmt.Errorf("%s: no blocks", caller)
+	}
+	first, last := blocks[0].Num(), blocks[len(blocks)-1].Num()
+	if uint64(first) != start {
+		const tag = "%s: rpc response contains invalid data. requested first: %d got: %d"
+		return fmt.Errorf(tag, caller, start, first)
+	}
+	if uint64(last) != start+limit-1 {
+		const tag = "%s: rpc response contains invalid data. requested last: %d got: %d"
+		return fmt.Errorf(tag, caller, start+limit-1, last)
+	}
+	return nil
+}
+
+func validate(caller string, start, limit uint64, blocks []eth.Block) error {
+	if err := validateBounds(caller, start, limit, blocks); err != nil {
+		return err
+	}
+	return validateLinkage(caller, blocks)
+}
 
-func (c *Client) Get(
-	ctx context.Context,
-	url string,
-	filter *glf.Filter,
-	start, limit uint64,
-) ([]eth.Block, error) {
-	t0 := time.Now()
-	defer func() {
-		slog.DebugContext(ctx,
-			"jrpc2-get",
-			"filter", filter,
-			"elapsed", time.Since(t0),
-		)
-	}()
+func validateLinkage(caller string, blocks []eth.Block) error {
+	for i := 1; i < len(blocks); i++ {
+		prev, curr := blocks[i-1], blocks[i]
+		if !bytes.Equal(curr.Header.Parent, prev.Hash()) {
+			slog.Error("rpc response contains invalid data",
+				"num", prev.Num(),
+				"hash", fmt.Sprintf("%.4x", prev.Header.Hash),
+				"next-num", curr.Num(),
+				"next-parent", fmt.Sprintf("%.4x", curr.Header.Parent),
+				"next-hash", fmt.Sprintf("%.4x", curr.Header.Hash),
+			)
+			return fmt.Errorf("%s: corrupt chain segment", caller)
+		}
+	}
+	return nil
+}
+
+type headerResp struct {
+	Error       Error `json:"error"`
+	*eth.Header `json:"result"`
+}
+
+// Returns the number of transactions in each block
+// from start to start+limit-1.
+func (c *Client) TxCounts(ctx context.Context, url string, start, limit uint64) (_ []uint64, err error) {
+	defer c.wrap(&err, url)
+	if err := c.checkLimit(limit); err != nil {
+		return nil, err
+	}
+	type countResp struct {
+		ID     string      `json:"id"`
+		Error  Error       `json:"error"`
+		Result *eth.Uint64 `json:"result"`
+	}
 	var (
-		blocks []eth.Block
-		err    error
+		prefix = fmt.Sprintf("txcount-%d-%d-%x", start, limit, randbytes())
+		reqs   = make([]request, limit)
+		resps  = make([]countResp, 0, limit)
+		ids    = make(map[string]uint64, limit)
 	)
-	switch {
-	case filter.UseBlocks:
-		blocks, err = c.bcache.get(c.nocache, ctx, url, start, limit, c.blocks)
-		if err != nil {
-			return nil, fmt.Errorf("getting blocks: %w", err)
+	for i := uint64(0); i < limit; i++ {
+		id := c.id("eth_getBlockTransactionCountByNumber", fmt.Sprintf("%s-%d", prefix, i))
+		ids[id] = i
+		reqs[i] = request{
+			ID:      id,
+			Version: c.version,
+			Method:  "eth_getBlockTransactionCountByNumber",
+			Params:  []any{eth.EncodeUint64(start + i)},
 		}
-	case filter.UseHeaders:
-		blocks, err = c.hcache.get(c.nocache, ctx, url, start, limit, c.headers)
-		if err != nil {
-			return nil, fmt.Errorf("getting headers: %w", err)
+	}
+	if err := c.do(ctx, url, &resps, reqs); err != nil {
+		return nil, fmt.Errorf("requesting tx counts: %w", err)
+	}
+	var (
+		counts = make([]uint64, limit)
+		found  = make([]bool, limit)
+	)
+	for i := range resps {
+		if resps[i].Error.Exists() {
+			const tag = "eth_getBlockTransactionCountByNumber"
+			return nil, fmt.Errorf("rpc=%s %w", tag, resps[i].Error)
 		}
-	default:
-		for i := uint64(0); i < limit; i++ {
-			blocks = append(blocks, eth.Block{
-				Header: eth.Header{
-					Number: eth.Uint64(start + i),
-				},
-			})
+		j, ok := ids[resps[i].ID]
+		if !ok {
+			return nil, fmt.Errorf("tx counts unexpected id: %s", resps[i].ID)
+		}
+		if resps[i].Result == nil {
+			return nil, &missingBlockError{start + j}
 		}
+		counts[j] = uint64(*resps[i].Result)
+		found[j] = true
 	}
-
-	bm := make(blockmap)
-	for i := range blocks {
-		bm[blocks[i].Num()] = &blocks[i]
+	for i := range found {
+		if !found[i] {
+			return nil, fmt.Errorf("tx counts missing block %d", start+uint64(i))
+		}
 	}
+	return counts, nil
+}
 
-	switch {
-	case filter.UseReceipts:
-		if err := c.receipts(ctx, url, bm, start, limit); err != nil {
-			return nil, fmt.Errorf("getting receipts: %w", err)
+type txHashesResp struct {
+	Error  Error `json:"error"`
+	Result *struct {
+		eth.Header
+		TxHashes []eth.Bytes `json:"transactions"`
+	} `json:"result"`
+}
+
+// Returns headers along with each block's tx hashes.
+// This is much smaller than fetching full transactions and is
+// useful when the hashes will be used for later lookups.
+// Only Idx and the hash are set on each tx.
+func (c *Client) HeadersWithTxHashes(ctx context.Context, url string, start, limit uint64) (_ []eth.Block, err error) {
+	defer c.wrap(&err, url)
+	if err := c.checkLimit(limit); err != nil {
+		return nil, err
+	}
+	var (
+		t0     = time.Now()
+		reqs   = make([]request, limit)
+		resps  = make([]txHashesResp, limit)
+		blocks = make([]eth.Block, limit)
+	)
+	for i := uint64(0); i < limit; i++ {
+		reqs[i] = request{
+			ID:      c.id("eth_getBlockByNumber", fmt.Sprintf("txhashes-%d-%d-%x", start, limit, randbytes())),
+			Version: c.version,
+			Method:  "eth_getBlockByNumber",
+			Params:  []any{eth.EncodeUint64(start + i), false},
 		}
-	case filter.UseLogs:
-		if err := c.logs(ctx, url, filter, bm, start, limit); err != nil {
-			return nil, fmt.Errorf("getting logs: %w", err)
+	}
+	err = c.do(ctx, url, &resps, reqs)
+	if err != nil {
+		return nil, fmt.Errorf("requesting tx hashes: %w", err)
+	}
+	for i := range resps {
+		if resps[i].Error.Exists() {
+			const tag = "eth_getBlockByNumber/txhashes"
+			return nil, fmt.Errorf("rpc=%s %w", tag, resps[i].Error)
 		}
-	case filter.UseTraces:
-		if err := c.traces(ctx, url, bm, start, limit); err != nil {
-			return nil, fmt.Errorf("getting traces: %w", err)
+		if resps[i].Result == nil {
+			return nil, &missingBlockError{start + uint64(i)}
+		}
+		blocks[i].Header = resps[i].Result.Header
+		blocks[i].Txs = make(eth.Txs, len(resps[i].Result.TxHashes))
+		for j, h := range resps[i].Result.TxHashes {
+			blocks[i].Txs[j].Idx = eth.Uint64(j)
+			blocks[i].Txs[j].PrecompHash = h
 		}
 	}
-	return blocks, nil
+	c.logger().DebugContext(ctx, "http-get-txhashes", "elapsed", time.Since(t0))
+	return blocks, c.validate("txhashes", start, limit, blocks)
 }
 
-type blockResp struct {
-	Error      `json:"error"`
-	*eth.Block `json:"result"`
+func (c *Client) headersReqs(start, limit uint64) ([]request, []headerResp, []eth.Block) {
+	var (
+		reqs   = make([]request, limit)
+		resps  = make([]headerResp, limit)
+		blocks = make([]eth.Block, limit)
+	)
+	for i := uint64(0); i < limit; i++ {
+		reqs[i] = request{
+			ID:      c.id("eth_getBlockByNumber", fmt.Sprintf("headers-%d-%d-%x", start, limit, randbytes())),
+			Version: c.version,
+			Method:  "eth_getBlockByNumber",
+			Params:  []any{eth.EncodeUint64(start + i), false},
+		}
+		resps[i].Header = &blocks[i].Header
+	}
+	return reqs, resps, blocks
 }
 
-type segment struct {
-	sync.Mutex
-	nreads int
-	done   bool
-	d      []eth.Block
+func checkHeaders(start uint64, resps []headerResp) error {
+	for i := range resps {
+		if resps[i].Error.Exists() {
+			const tag = "eth_getBlockByNumber/headers"
+			return fmt.Errorf("rpc=%s %w", tag, resps[i].Error)
+		}
+		if resps[i].Header == nil {
+			return &missingBlockError{start + uint64(i)}
+		}
+	}
+	return nil
 }
 
-type cache struct {
-	sync.Mutex
-	maxreads int
-	segments map[key]*segment
+func (c *Client) headers(ctx context.Context, url string, start, limit uint64) ([]eth.Block, error) {
+	var (
+		t0                  = time.Now()
+		reqs, resps, blocks = c.headersReqs(start, limit)
+	)
+	err := c.do(ctx, url, &resps, reqs)
+	if err != nil {
+		return nil, fmt.Errorf("requesting headers: %w", err)
+	}
+	if len(resps) < len(reqs) {
+		return nil, &ErrIncompleteBatch{Want: len(reqs), Got: len(resps)}
+	}
+	if err := checkHeaders(start, resps); err != nil {
+		return nil, err
+	}
+	c.logger().DebugContext(ctx, "http-get-headers", "elapsed", time.Since(t0))
+	return blocks, c.validate("headers", start, limit, blocks)
 }
 
-type getter func(ctx context.Context, url string, start, limit uint64) ([]eth.Block, error)
+type receiptResult struct {
+	BlockHash           eth.Bytes    `json:"blockHash"`
+	BlockNum            eth.Uint64   `json:"blockNumber"`
+	TxHash              eth.Bytes    `json:"transactionHash"`
+	TxIdx               eth.Uint64   `json:"transactionIndex"`
+	TxType              *eth.Byte    `json:"type"`
+	TxFrom              eth.Bytes    `json:"from"`
+	TxTo                eth.Bytes    `json:"to"`
+	Status              *eth.Byte    `json:"status"`
+	GasUsed             *eth.Uint64  `json:"gasUsed"`
+	EffectiveGasPrice   *uint256.Int `json:"effectiveGasPrice"`
+	Logs                eth.Logs     `json:"logs"`
+	ContractAddress     eth.Bytes    `json:"contractAddress"`
+	L1BaseFeeScalar     *uint256.Int `json:"l1BaseFeeScalar,omitempty"`
+	L1BlobBaseFee       *uint256.Int `json:"l1BlobBaseFee,omitempty"`
+	L1BlobBaseFeeScalar *uint256.Int `json:"l1BlobBaseFeeScalar,omitempty"`
+	L1Fee               *uint256.Int `json:"l1Fee,omitempty"`
+	L1GasPrice          *uint256.Int `json:"l1GasPrice,omitempty"`
+	L1GasUsed           *eth.Uint64  `json:"l1GasUsed,omitempty"`
 
-func (c *cache) pruneMaxRead() {
-	for k, v := range c.segments {
-		v.Lock()
-		if v.nreads >= c.maxreads {
-			delete(c.segments, k)
+	// Only used to verify the receipts root
+	CumulativeGasUsed     *eth.Uint64 `json:"cumulativeGasUsed"`
+	LogsBloom             eth.Bytes   `json:"logsBloom"`
+	Root                  eth.Bytes   `json:"root"`
+	DepositNonce          *eth.Uint64 `json:"depositNonce"`
+	DepositReceiptVersion *eth.Uint64 `json:"depositReceiptVersion"`
+}
+
+type receiptResp struct {
+	Error  Error           `json:"error"`
+	Result []receiptResult `json:"result"`
+}
+
+func (c *Client) receiptsReqs(start, limit uint64) []request {
+	reqs := make([]request, limit)
+	for i := uint64(0); i < limit; i++ {
+		reqs[i] = request{
+			ID:      c.id("eth_getBlockReceipts", fmt.Sprintf("receipts-%d-%d-%x", start, limit, randbytes())),
+			Version: c.version,
+			Method:  "eth_getBlockReceipts",
+			Params:  []any{eth.EncodeUint64(start + i)},
 		}
-		v.Unlock()
 	}
+	return reqs
 }
 
-func (c *cache) pruneSegments() {
-	const size = 5
-	if len(c.segments) <= size {
-		return
+func (c *Client) receipts(ctx context.Context, url string, filter *glf.Filter, bm blockmap, start, limit uint64) error {
+	var (
+		reqs = c.receiptsReqs(start, limit)
+		seen = make(map[uint64]bool, limit)
+		p    = newProjection(filter)
+	)
+	if c.streamf != nil {
+		var n int
+		err := c.do(ctx, url, stream(func(dec *json.Decoder) error {
+			resp := receiptResp{}
+			if err := dec.Decode(&resp); err != nil {
+				return err
+			}
+			n++
+			if err := c.verifyReceiptsRoot(bm, start+uint64(n-1), resp.Result); err != nil {
+				return err
+			}
+			p.apply(resp.Result)
+			b, err := c.addReceipts(ctx, bm, start, limit, &resp)
+			if err != nil || b == nil {
+				return err
+			}
+			seen[b.Num()] = true
+			c.streamf(b)
+			return nil
+		}), reqs)
+		if err != nil {
+			return fmt.Errorf("requesting receipts: %w", err)
+		}
+		if n < len(reqs) {
+			return &ErrIncompleteBatch{Want: len(reqs), Got: n}
+		}
+		return c.checkReceiptCoverage(bm, seen)
 	}
-	var keys []key
-	for k := range c.segments {
-		keys = append(keys, k)
+	resps := make([]receiptResp, limit)
+	err := c.do(ctx, url, &resps, reqs)
+	if err != nil {
+		return fmt.Errorf("requesting receipts: %w", err)
 	}
-	sort.Slice(keys, func(i, j int) bool {
-		return keys[i].a > keys[j].a
-	})
-	for i := range keys[size:] {
-		delete(c.segments, keys[size+i])
+	if len(resps) < len(reqs) {
+		return &ErrIncompleteBatch{Want: len(reqs), Got: len(resps)}
+	}
+	for i := range resps {
+		if err := c.verifyReceiptsRoot(bm, start+uint64(i), resps[i].Result); err != nil {
+			return err
+		}
+		p.apply(resps[i].Result)
 	}
+	return c.addReceiptResps(ctx, bm, start, limit, resps)
 }
 
-func (c *cache) get(nocache bool, ctx context.Context, url string, start, limit uint64, f getter) ([]eth.Block, error) {
-	if nocache {
-		return f(ctx, url, start, limit)
+func (c *Client) addReceiptResps(ctx context.Context, bm blockmap, start, limit uint64, resps []receiptResp) error {
+	seen := make(map[uint64]bool, limit)
+	for i := range resps {
+		if resps[i].Error.Exists() {
+			const tag = "eth_getBlockReceipts"
+			return fmt.Errorf("rpc=%s %w", tag, resps[i].Error)
+		}
 	}
-	c.Lock()
-	if c.segments == nil {
-		c.segments = make(map[key]*segment)
+	for i := range resps {
+		b, err := c.addReceipts(ctx, bm, start, limit, &resps[i])
+		if err != nil {
+			return err
+		}
+		if b != nil {
+			seen[b.Num()] = true
+		}
 	}
-	c.pruneMaxRead()
-	seg, ok := c.segments[key{start, limit}]
-	if !ok {
-		seg = &segment{}
-		c.segments[key{start, limit}] = seg
+	return c.checkReceiptCoverage(bm, seen)
+}
+
+// In strict mode, returns an error for the first block
+// that has transactions but didn't receive receipts.
+func (c *Client) checkReceiptCoverage(bm blockmap, seen map[uint64]bool) error {
+	if !c.strictReceipts {
+		return nil
 	}
-	c.pruneSegments()
-	c.Unlock()
+	for n, b := range bm {
+		if len(b.Txs) > 0 && !seen[n] {
+			return fmt.Errorf("missing receipts for block %d", n)
+		}
+	}
+	return nil
+}
 
-	seg.Lock()
-	defer seg.Unlock()
-	seg.nreads++
-	if seg.done {
-		return seg.d, nil
+// Returns an error if required receipt fields are missing
+// and the client was configured with WithRequireReceiptFields.
+func (c *Client) checkReceipt(r *receiptResult) error {
+	if !c.requireReceiptFields {
+		return nil
+	}
+	var missing []string
+	if r.Status == nil {
+		missing = append(missing, "status")
+	}
+	if r.GasUsed == nil {
+		missing = append(missing, "gasUsed")
 	}
+	if r.EffectiveGasPrice == nil {
+		missing = append(missing, "effectiveGasPrice")
+	}
+	if len(missing) > 0 {
+		const tag = "eth_getBlockReceipts incomplete receipt. block=%d tx=%d missing=%s"
+		return fmt.Errorf(tag, r.BlockNum, r.TxIdx, strings.Join(missing, ","))
+	}
+	return nil
+}
 
-	blocks, err := f(ctx, url, start, limit)
-	if err != nil {
-		return nil, fmt.Errorf("cache get: %w", err)
+// Copies the receipt data in resp onto the block in bm.
+// Returns the updated block or nil if resp was empty.
+func (c *Client) addReceipts(ctx context.Context, bm blockmap, start, limit uint64, resp *receiptResp) (*eth.Block, error) {
+	if resp.Error.Exists() {
+		const tag = "eth_getBlockReceipts"
+		return nil, fmt.Errorf("rpc=%s %w", tag, resp.Error)
+	}
+	if len(resp.Result) == 0 {
+		if c.strictReceipts && resp.Result == nil {
+			return nil, fmt.Errorf("eth_getBlockReceipts: no rpc error but null result")
+		}
+		c.logger().ErrorContext(ctx, "no rpc error but empty result")
+		return nil, nil
+	}
+	blockNum := uint64(resp.Result[0].BlockNum)
+	if blockNum < start || blockNum > start+limit {
+		const tag = "eth_getBlockReceipts out of range block. num=%d start=%d lim=%d"
+		return nil, fmt.Errorf(tag, blockNum, start, limit)
 	}
+	b, ok := bm[blockNum]
+	if !ok {
+		return nil, fmt.Errorf("block not found")
+	}
+	b.Header.Hash.Write(resp.Result[0].BlockHash)
+	for j := range resp.Result {
+		if err := c.checkReceipt(&resp.Result[j]); err != nil {
+			return nil, err
+		}
+		// tx.Data (calldata) and the fee fields are
+		// populated by the block fetch and are
+		// intentionally left as-is here. Fields that are
+		// missing from the receipt don't overwrite the
+		// block's values.
+		tx := b.Tx(uint64(resp.Result[j].TxIdx))
+		tx.PrecompHash.Write(resp.Result[j].TxHash)
+		if t := resp.Result[j].TxType; t != nil {
+			tx.Type.Write(byte(*t))
+		}
+		if from := resp.Result[j].TxFrom; len(from) > 0 {
+			tx.From.Write(from)
+		}
+		if to := resp.Result[j].TxTo; len(to) > 0 {
+			tx.To.Write(to)
+		}
+		resp.Result[j].copy(&tx.Receipt)
+	}
+	return b, nil
+}
 
-	seg.d = blocks
-	seg.done = true
-	return seg.d, nil
+// The receipt fields needed by a filter (see [glf.Filter.Needs]).
+// Unneeded fields are dropped after decoding so that they
+// aren't retained by the blocks.
+type projection struct {
+	logs, l1, contract bool
 }
 
-func (c *Client) blocks(ctx context.Context, url string, start, limit uint64) ([]eth.Block, error) {
-	var (
-		t0     = time.Now()
-		reqs   = make([]request, limit)
-		resps  = make([]blockResp, limit)
-		blocks = make([]eth.Block, limit)
-	)
-	for i := uint64(0); i < limit; i++ {
-		reqs[i] = request{
-			ID:      fmt.Sprintf("blocks-%d-%d-%x", start, limit, randbytes()),
-			Version: "2.0",
-			Method:  "eth_getBlockByNumber",
-			Params:  []any{eth.EncodeUint64(start + i), true},
-		}
-		resps[i].Block = &blocks[i]
+func newProjection(f *glf.Filter) projection {
+	p := projection{
+		logs: f.Needs("log_addr", "log_idx"),
+		l1: f.Needs(
+			"tx_l1_base_fee_scalar",
+			"tx_l1_blob_base_fee",
+			"tx_l1_blob_base_fee_scalar",
+			"tx_l1_fee",
+			"tx_l1_gas_price",
+			"tx_l1_gas_used",
+		),
+		contract: f.Needs("tx_contract_address"),
 	}
-	err := c.do(ctx, url, &resps, reqs)
-	if err != nil {
-		return nil, fmt.Errorf("requesting blocks: %w", err)
+	// Event inputs are decoded from the logs
+	for _, topics := range f.Topics() {
+		for _, t := range topics {
+			if len(eth.DecodeHex(t)) > 0 {
+				p.logs = true
+			}
+		}
 	}
-	for i := range resps {
-		if resps[i].Error.Exists() {
-			const tag = "eth_getBlockByNumber"
-			return nil, fmt.Errorf("rpc=%s %w", tag, resps[i].Error)
+	return p
+}
+
+func (p projection) apply(results []receiptResult) {
+	for i := range results {
+		r := &results[i]
+		if !p.logs {
+			r.Logs = nil
+		}
+		if !p.l1 {
+			r.L1BaseFeeScalar = nil
+			r.L1BlobBaseFee = nil
+			r.L1BlobBaseFeeScalar = nil
+			r.L1Fee = nil
+			r.L1GasPrice = nil
+			r.L1GasUsed = nil
+		}
+		if !p.contract {
+			r.ContractAddress = nil
 		}
 	}
-	slog.DebugContext(ctx, "http-get-blocks", "elapsed", time.Since(t0))
-	return blocks, validate("blocks", start, limit, blocks)
 }
 
-func validate(caller string, start, limit uint64, blocks []eth.Block) error {
-	if len(blocks) == 0 {
-		return fmt.Errorf("%s: no blocks", caller)
+// Returned when a root computed from the block's
+// transactions or receipts doesn't match its header.
+type RootError struct {
+	Num       uint64
+	Root      string // transactionsRoot or receiptsRoot
+	Want, Got []byte
+}
+
+func (e *RootError) Error() string {
+	return fmt.Sprintf("block %d %s mismatch. want=%.4x got=%.4x", e.Num, e.Root, e.Want, e.Got)
+}
+
+// Checks the receipts of block num against the
+// receiptsRoot of its header. Empty results are checked
+// too since they must match the empty root. Blocks without
+// a receiptsRoot (ie the filter didn't need headers)
+// aren't checked.
+func (c *Client) verifyReceiptsRoot(bm blockmap, num uint64, results []receiptResult) error {
+	if !c.verifyRoots {
+		return nil
 	}
-	first, last := blocks[0].Num(), blocks[len(blocks)-1].Num()
-	if uint64(first) != start {
-		const tag = "%s: rpc response contains invalid data. requested first: %d got: %d"
-		return fmt.Errorf(tag, caller, start, first)
+	b, ok := bm[num]
+	if !ok || len(b.ReceiptsRoot) == 0 {
+		return nil
 	}
-	if uint64(last) != start+limit-1 {
-		const tag = "%s: rpc response contains invalid data. requested last: %d got: %d"
-		return fmt.Errorf(tag, caller, start+limit-1, last)
+	sorted := make([]*receiptResult, len(results))
+	for i := range results {
+		sorted[i] = &results[i]
 	}
-	for i := 1; i < len(blocks); i++ {
-		prev, curr := blocks[i-1], blocks[i]
-		if !bytes.Equal(curr.Header.Parent, prev.Hash()) {
-			slog.Error("rpc response contains invalid data",
-				"num", prev.Num(),
-				"hash", fmt.Sprintf("%.4x", prev.Header.Hash),
-				"next-num", curr.Num(),
-				"next-parent", fmt.Sprintf("%.4x", curr.Header.Parent),
-				"next-hash", fmt.Sprintf("%.4x", curr.Header.Hash),
-			)
-			return fmt.Errorf("%s: corrupt chain segment", caller)
-		}
+	slices.SortFunc(sorted, func(a, b *receiptResult) int {
+		return cmp.Compare(a.TxIdx, b.TxIdx)
+	})
+	encoded := make([][]byte, len(sorted))
+	for i := range sorted {
+		encoded[i] = sorted[i].encode()
+	}
+	if root := eth.DeriveRoot(encoded); !bytes.Equal(root, b.ReceiptsRoot) {
+		return &RootError{Num: num, Root: "receiptsRoot", Want: b.ReceiptsRoot, Got: root}
 	}
 	return nil
 }
 
-type headerResp struct {
-	Error       `json:"error"`
-	*eth.Header `json:"result"`
-}
-
-func (c *Client) headers(ctx context.Context, url string, start, limit uint64) ([]eth.Block, error) {
-	var (
-		t0     = time.Now()
-		reqs   = make([]request, limit)
-		resps  = make([]headerResp, limit)
-		blocks = make([]eth.Block, limit)
-	)
-	for i := uint64(0); i < limit; i++ {
-		reqs[i] = request{
-			ID:      fmt.Sprintf("headers-%d-%d-%x", start, limit, randbytes()),
-			Version: "2.0",
-			Method:  "eth_getBlockByNumber",
-			Params:  []any{eth.EncodeUint64(start + i), false},
+// Returns the consensus encoding of the receipt.
+// Pre-Byzantium receipts have a state root instead
+// of a status. Deposit transactions (OP Stack) append
+// the deposit nonce and receipt version when present.
+func (rr *receiptResult) encode() []byte {
+	var status []byte
+	switch {
+	case rr.Status != nil && *rr.Status == 1:
+		status = eth.EncodeRLPUint(1)
+	case rr.Status != nil:
+		status = eth.EncodeRLPBytes(nil)
+	default:
+		status = eth.EncodeRLPBytes(rr.Root)
+	}
+	var cumGas uint64
+	if rr.CumulativeGasUsed != nil {
+		cumGas = uint64(*rr.CumulativeGasUsed)
+	}
+	logs := make([][]byte, len(rr.Logs))
+	for i := range rr.Logs {
+		topics := make([][]byte, len(rr.Logs[i].Topics))
+		for j := range rr.Logs[i].Topics {
+			topics[j] = eth.EncodeRLPBytes(rr.Logs[i].Topics[j])
 		}
-		resps[i].Header = &blocks[i].Header
+		logs[i] = eth.EncodeRLPList(
+			eth.EncodeRLPBytes(rr.Logs[i].Address),
+			eth.EncodeRLPList(topics...),
+			eth.EncodeRLPBytes(rr.Logs[i].Data),
+		)
 	}
-	err := c.do(ctx, url, &resps, reqs)
-	if err != nil {
-		return nil, fmt.Errorf("requesting headers: %w", err)
+	fields := [][]byte{
+		status,
+		eth.EncodeRLPUint(cumGas),
+		eth.EncodeRLPBytes(rr.LogsBloom),
+		eth.EncodeRLPList(logs...),
 	}
-	for i := range resps {
-		if resps[i].Error.Exists() {
-			const tag = "eth_getBlockByNumber/headers"
-			return nil, fmt.Errorf("rpc=%s %w", tag, resps[i].Error)
+	if rr.DepositNonce != nil {
+		fields = append(fields, eth.EncodeRLPUint(uint64(*rr.DepositNonce)))
+		if rr.DepositReceiptVersion != nil {
+			fields = append(fields, eth.EncodeRLPUint(uint64(*rr.DepositReceiptVersion)))
 		}
 	}
-	slog.DebugContext(ctx, "http-get-headers", "elapsed", time.Since(t0))
-	return blocks, validate("headers", start, limit, blocks)
-}
-
-type receiptResult struct {
-	BlockHash         eth.Bytes   `json:"blockHash"`
-	BlockNum          eth.Uint64  `json:"blockNumber"`
-	TxHash            eth.Bytes   `json:"transactionHash"`
-	TxIdx             eth.Uint64  `json:"transactionIndex"`
-	TxType            eth.Byte    `json:"type"`
-	TxFrom            eth.Bytes   `json:"from"`
-	TxTo              eth.Bytes   `json:"to"`
-	Status            eth.Byte    `json:"status"`
-	GasUsed           eth.Uint64  `json:"gasUsed"`
-	EffectiveGasPrice uint256.Int `json:"effectiveGasPrice"`
-	Logs              eth.Logs    `json:"logs"`
-	ContractAddress   eth.Bytes   `json:"contractAddress"`
-	L1BaseFeeScalar     *uint256.Int `json:"l1BaseFeeScalar,omitempty"`
-	L1BlobBaseFee       *uint256.Int `json:"l1BlobBaseFee,omitempty"`
-	L1BlobBaseFeeScalar *uint256.Int `json:"l1BlobBaseFeeScalar,omitempty"`
-	L1Fee               *uint256.Int `json:"l1Fee,omitempty"`
-	L1GasPrice          *uint256.Int `json:"l1GasPrice,omitempty"`
-	L1GasUsed           *eth.Uint64  `json:"l1GasUsed,omitempty"`
+	res := eth.EncodeRLPList(fields...)
+	if rr.TxType != nil && *rr.TxType != 0 {
+		res = append([]byte{byte(*rr.TxType)}, res...)
+	}
+	return res
 }
 
-type receiptResp struct {
-	Error  `json:"error"`
-	Result []receiptResult `json:"result"`
+type rawTxResp struct {
+	Error  Error     `json:"error"`
+	Result eth.Bytes `json:"result"`
 }
 
-func (c *Client) receipts(ctx context.Context, url string, bm blockmap, start, limit uint64) error {
+// Requests the encoded transactions of each block and
+// checks them against the block's transactionsRoot and
+// the hashes of the decoded transactions. The decoded
+// transactions don't retain every signed field so the
+// raw encoding is requested instead. Not every node
+// supports eth_getRawTransactionByBlockNumberAndIndex.
+func (c *Client) verifyTxRoots(ctx context.Context, url string, blocks []eth.Block) error {
+	if !c.verifyRoots {
+		return nil
+	}
 	var (
-		reqs  = make([]request, limit)
-		resps = make([]receiptResp, limit)
+		reqs  []request
+		dests []*rawTxResp
+		resps = make([][]rawTxResp, len(blocks))
 	)
-	for i := uint64(0); i < limit; i++ {
-		reqs[i] = request{
-			ID:      fmt.Sprintf("receipts-%d-%d-%x", start, limit, randbytes()),
-			Version: "2.0",
-			Method:  "eth_getBlockReceipts",
-			Params:  []any{eth.EncodeUint64(start + i)},
+	for i := range blocks {
+		if len(blocks[i].TxRoot) == 0 {
+			continue
+		}
+		resps[i] = make([]rawTxResp, len(blocks[i].Txs))
+		for j := range blocks[i].Txs {
+			r := request{
+				ID:      c.id("eth_getRawTransactionByBlockNumberAndIndex", fmt.Sprintf("rawtx-%d-%d-%x", blocks[i].Num(), j, randbytes())),
+				Version: c.version,
+				Method:  "eth_getRawTransactionByBlockNumberAndIndex",
+				Params:  []any{eth.EncodeUint64(blocks[i].Num()), eth.EncodeUint64(uint64(j))},
+			}
+			reqs = append(reqs, r)
+			dests = append(dests, &resps[i][j])
 		}
 	}
-	err := c.do(ctx, url, &resps, reqs)
-	if err != nil {
-		return fmt.Errorf("requesting receipts: %w", err)
+	n := uint64(maxBatchSize)
+	if c.maxLimit > 0 {
+		n = min(n, c.maxLimit)
 	}
-	for i := range resps {
-		if resps[i].Error.Exists() {
-			const tag = "eth_getBlockReceipts"
-			return fmt.Errorf("rpc=%s %w", tag, resps[i].Error)
+	for i := uint64(0); i < uint64(len(reqs)); i += n {
+		j := min(i+n, uint64(len(reqs)))
+		ids := make(byID, j-i)
+		for k := i; k < j; k++ {
+			ids[reqs[k].ID] = dests[k]
+		}
+		if err := c.do(ctx, url, ids, reqs[i:j]); err != nil {
+			return fmt.Errorf("requesting raw transactions: %w", err)
 		}
 	}
-	for i := range resps {
-		if len(resps[i].Result) == 0 {
-			slog.ErrorContext(ctx, "no rpc error but empty result")
+	for i := range blocks {
+		if len(blocks[i].TxRoot) == 0 {
 			continue
 		}
-		blockNum := uint64(resps[i].Result[0].BlockNum)
-		if blockNum < start || blockNum > start+limit {
-			const tag = "eth_getBlockReceipts out of range block. num=%d start=%d lim=%d"
-			return fmt.Errorf(tag, blockNum, start, limit)
-		}
-		b, ok := bm[blockNum]
-		if !ok {
-			return fmt.Errorf("block not found")
+		encoded := make([][]byte, len(resps[i]))
+		for j := range resps[i] {
+			if resps[i][j].Error.Exists() {
+				const tag = "eth_getRawTransactionByBlockNumberAndIndex"
+				return fmt.Errorf("rpc=%s %w", tag, resps[i][j].Error)
+			}
+			if len(resps[i][j].Result) == 0 {
+				return fmt.Errorf("missing raw transaction. block=%d tx=%d", blocks[i].Num(), j)
+			}
+			if h := eth.Keccak(resps[i][j].Result); !bytes.Equal(h, blocks[i].Txs[j].Hash()) {
+				const msg = "raw transaction hash mismatch. block=%d tx=%d want=%.4x got=%.4x"
+				return fmt.Errorf(msg, blocks[i].Num(), j, blocks[i].Txs[j].Hash(), h)
+			}
+			encoded[j] = resps[i][j].Result
 		}
-		b.Header.Hash.Write(resps[i].Result[0].BlockHash)
-		for j := range resps[i].Result {
-			tx := b.Tx(uint64(resps[i].Result[j].TxIdx))
-			tx.PrecompHash.Write(resps[i].Result[j].TxHash)
-			tx.Type.Write(byte(resps[i].Result[j].TxType))
-			tx.From.Write(resps[i].Result[j].TxFrom)
-			tx.To.Write(resps[i].Result[j].TxTo)
-			tx.Status.Write(byte(resps[i].Result[j].Status))
-			tx.GasUsed = resps[i].Result[j].GasUsed
-			tx.EffectiveGasPrice = resps[i].Result[j].EffectiveGasPrice
-			tx.Logs = make([]eth.Log, len(resps[i].Result[j].Logs))
-			tx.ContractAddress.Write(resps[i].Result[j].ContractAddress)
-			copy(tx.Logs, resps[i].Result[j].Logs)
-			tx.L1BaseFeeScalar = resps[i].Result[j].L1BaseFeeScalar
-			tx.L1BlobBaseFee = resps[i].Result[j].L1BlobBaseFee
-			tx.L1BlobBaseFeeScalar = resps[i].Result[j].L1BlobBaseFeeScalar
-			tx.L1Fee = resps[i].Result[j].L1Fee
-			tx.L1GasPrice = resps[i].Result[j].L1GasPrice
-			tx.L1GasUsed = resps[i].Result[j].L1GasUsed
+		root := eth.DeriveRoot(encoded)
+		if !bytes.Equal(root, blocks[i].TxRoot) {
+			return &RootError{
+				Num:  blocks[i].Num(),
+				Root: "transactionsRoot",
+				Want: blocks[i].TxRoot,
+				Got:  root,
+			}
 		}
 	}
 	return nil
 }
 
+// Missing optional fields are zeroed in dst
+func (rr *receiptResult) copy(dst *eth.Receipt) {
+	dst.Status = 0
+	if rr.Status != nil {
+		dst.Status = *rr.Status
+	}
+	dst.GasUsed = 0
+	if rr.GasUsed != nil {
+		dst.GasUsed = *rr.GasUsed
+	}
+	dst.EffectiveGasPrice.Clear()
+	if rr.EffectiveGasPrice != nil {
+		dst.EffectiveGasPrice = *rr.EffectiveGasPrice
+	}
+	dst.Logs = make([]eth.Log, len(rr.Logs))
+	copy(dst.Logs, rr.Logs)
+	dst.ContractAddress.Write(rr.ContractAddress)
+	dst.L1BaseFeeScalar = rr.L1BaseFeeScalar
+	dst.L1BlobBaseFee = rr.L1BlobBaseFee
+	dst.L1BlobBaseFeeScalar = rr.L1BlobBaseFeeScalar
+	dst.L1Fee = rr.L1Fee
+	dst.L1GasPrice = rr.L1GasPrice
+	dst.L1GasUsed = rr.L1GasUsed
+}
+
 type logResult struct {
 	*eth.Log
 	BlockHash eth.Bytes  `json:"blockHash"`
@@ -739,71 +4576,220 @@ type logResult struct {
 }
 
 type logResp struct {
-	Error  `json:"error"`
+	Error  Error       `json:"error"`
 	Result []logResult `json:"result"`
 }
 
+type logFilter struct {
+	From      string     `json:"fromBlock,omitempty"`
+	To        string     `json:"toBlock,omitempty"`
+	BlockHash string     `json:"blockHash,omitempty"`
+	Address   []string   `json:"address"`
+	Topics    [][]string `json:"topics"`
+}
+
+func newLogFilter(filter *glf.Filter, start, limit uint64) logFilter {
+	return logFilter{
+		From:    eth.EncodeUint64(start),
+		To:      eth.EncodeUint64(start + limit - 1),
+		Address: filter.Addresses(),
+		Topics:  filter.Topics(),
+	}
+}
+
+// Splits the filter's addresses into groups of at most n.
+// Returns lf unchanged when splitting is disabled or
+// isn't needed.
+func (lf logFilter) split(n int) []logFilter {
+	if n <= 0 || len(lf.Address) <= n {
+		return []logFilter{lf}
+	}
+	var res []logFilter
+	for i := 0; i < len(lf.Address); i += n {
+		g := lf
+		g.Address = lf.Address[i:min(i+n, len(lf.Address))]
+		res = append(res, g)
+	}
+	return res
+}
+
 func (c *Client) logs(ctx context.Context, url string, filter *glf.Filter, bm blockmap, start, limit uint64) error {
 	var (
 		t0        = time.Now()
-		fromBlock = start
-		toBlock   = start + limit - 1
-		lf        = struct {
-			From    string     `json:"fromBlock"`
-			To      string     `json:"toBlock"`
-			Address []string   `json:"address"`
-			Topics  [][]string `json:"topics"`
-		}{
-			From:    eth.EncodeUint64(fromBlock),
-			To:      eth.EncodeUint64(toBlock),
-			Address: filter.Addresses(),
-			Topics:  filter.Topics(),
+		blockHash []byte
+		lf        = newLogFilter(filter, start, limit)
+	)
+	// A single block whose hash is already known is pinned
+	// by hash so that the logs can't come from a reorged sibling.
+	if b, ok := bm[start]; ok && limit == 1 {
+		b.Lock()
+		blockHash = append(blockHash, b.Header.Hash...)
+		b.Unlock()
+	}
+	if len(blockHash) > 0 {
+		lf.From, lf.To = "", ""
+		lf.BlockHash = eth.EncodeHex(blockHash)
+	}
+	var (
+		groups  = lf.split(c.logsAddrGroup)
+		results = make([][]logResult, len(groups))
+		eg      errgroup.Group
+	)
+	for i := range groups {
+		i := i
+		// Only the first request checks that
+		// the node has the end of the range.
+		eg.Go(func() error {
+			var err error
+			results[i], err = c.getLogs(ctx, url, start, limit, groups[i], i == 0)
+			return err
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	if err := addLogs(bm, start, limit, blockHash, results); err != nil {
+		return err
+	}
+	var nlogs int
+	for i := range results {
+		nlogs += len(results[i])
+	}
+	c.logger().DebugContext(ctx, "http-get-logs",
+		"nlogs", nlogs,
+		"elapsed", time.Since(t0),
+	)
+	return nil
+}
+
+// Requests the logs matching filter for each of the blocks
+// in hashes using eth_getLogs with a blockHash. Unlike a
+// range query, the blocks don't need to be contiguous or
+// canonical which makes this useful for re-fetching the
+// blocks affected by a reorg. The requests are sent in a
+// single batch.
+//
+// The result is keyed by the hex encoded block hash and has
+// an entry for every hash, including blocks without matching
+// logs. Logs are in log index order.
+func (c *Client) LogsByBlockHashes(ctx context.Context, url string, hashes [][]byte, filter *glf.Filter) (_ map[string][]eth.Log, err error) {
+	defer c.wrap(&err, url)
+	var (
+		res   = make(map[string][]eth.Log, len(hashes))
+		ids   = byID{}
+		reqs  []request
+		resps []*logResp
+		keys  []string // block hash of resps[i]
+	)
+	for _, h := range hashes {
+		k := eth.EncodeHex(h)
+		if _, ok := res[k]; ok {
+			continue
 		}
-		resp = []any{
-			&headerResp{},
-			&logResp{},
+		res[k] = []eth.Log{}
+		lf := logFilter{
+			BlockHash: k,
+			Address:   filter.Addresses(),
+			Topics:    filter.Topics(),
 		}
-	)
-	err := c.do(ctx, url, &resp, []request{
-		request{
-			ID:      fmt.Sprintf("blocks-%d-%d-%x", start, limit, randbytes()),
-			Version: "2.0",
-			Method:  "eth_getBlockByNumber",
-			Params:  []any{lf.To, false},
-		},
-		request{
-			ID:      fmt.Sprintf("logs-%d-%d-%x", start, limit, randbytes()),
-			Version: "2.0",
-			Method:  "eth_getLogs",
-			Params:  []any{lf},
-		},
+		for _, g := range lf.split(c.logsAddrGroup) {
+			r := request{
+				ID:      c.id("eth_getLogs", fmt.Sprintf("logs-%s-%x", k, randbytes())),
+				Version: c.version,
+				Method:  "eth_getLogs",
+				Params:  []any{g},
+			}
+			resp := &logResp{}
+			ids[r.ID] = resp
+			reqs = append(reqs, r)
+			resps = append(resps, resp)
+			keys = append(keys, k)
+		}
+	}
+	if len(reqs) == 0 {
+		return res, nil
+	}
+	// byID fails with *ErrIncompleteBatch when a hash gets
+	// no response so a missing response can't pass for no logs
+	if err := c.do(ctx, url, ids, reqs); err != nil {
+		return nil, fmt.Errorf("requesting logs: %w", err)
+	}
+	type logKey struct {
+		blockHash string
+		idx       eth.Uint64
+	}
+	seen := map[logKey]struct{}{}
+	for i, resp := range resps {
+		if resp.Error.Exists() {
+			return nil, fmt.Errorf("rpc=eth_getLogs %w", resp.Error)
+		}
+		for j := range resp.Result {
+			l := &resp.Result[j]
+			if got := eth.EncodeHex(l.BlockHash); got != keys[i] {
+				const tag = "eth_getLogs block hash mismatch. want=%s got=%s"
+				return nil, fmt.Errorf(tag, keys[i], got)
+			}
+			lk := logKey{keys[i], l.Idx}
+			if _, ok := seen[lk]; ok {
+				continue
+			}
+			seen[lk] = struct{}{}
+			res[keys[i]] = append(res[keys[i]], *l.Log)
+		}
+	}
+	for k := range res {
+		slices.SortFunc(res[k], func(a, b eth.Log) int {
+			return cmp.Compare(a.Idx, b.Idx)
+		})
+	}
+	return res, nil
+}
+
+// Merges the results from each address group and adds
+// the logs to the blocks in bm. When blockHash is set,
+// every log must belong to that block.
+func addLogs(bm blockmap, start, limit uint64, blockHash []byte, results [][]logResult) error {
+	lresp := &logResp{}
+	for i := range results {
+		lresp.Result = append(lresp.Result, results[i]...)
+	}
+	// Providers don't guarantee the order of the logs and
+	// address groups are merged so sort into canonical order.
+	slices.SortStableFunc(lresp.Result, func(a, b logResult) int {
+		if a.BlockNum != b.BlockNum {
+			return cmp.Compare(a.BlockNum, b.BlockNum)
+		}
+		return cmp.Compare(a.Idx, b.Idx)
 	})
-	if err != nil {
-		return fmt.Errorf("making logs request: %w", err)
+	type logKey struct {
+		blockHash string
+		idx       eth.Uint64
 	}
 	var (
-		hresp = resp[0].(*headerResp)
-		lresp = resp[1].(*logResp)
+		logsByTx = map[key][]logResult{}
+		seen     = map[logKey]struct{}{}
 	)
-	switch {
-	case hresp.Error.Exists():
-		return fmt.Errorf("rpc=eth_getLogs/eth_getBlockByNumber %w", lresp.Error)
-	case lresp.Error.Exists():
-		return fmt.Errorf("rpc=eth_getLogs %w", lresp.Error)
-	case hresp.Header == nil:
-		return fmt.Errorf("eth backend missing logs for block: %d", toBlock)
-	}
-	var logsByTx = map[key][]logResult{}
 	for i := range lresp.Result {
 		var (
 			blockNum = uint64(lresp.Result[i].BlockNum)
 			txIdx    = uint64(lresp.Result[i].TxIdx)
 			k        = key{blockNum, txIdx}
+			lk       = logKey{string(lresp.Result[i].BlockHash), lresp.Result[i].Idx}
 		)
+		// Overlapping ranges from retries or chunking can
+		// return the same log more than once.
+		if _, ok := seen[lk]; ok {
+			continue
+		}
+		seen[lk] = struct{}{}
 		if blockNum < start || blockNum >= start+limit {
 			const tag = "eth_getLogs out of range block. num=%d start=%d lim=%d"
 			return fmt.Errorf(tag, blockNum, start, limit)
 		}
+		if len(blockHash) > 0 && !bytes.Equal(blockHash, lresp.Result[i].BlockHash) {
+			const tag = "eth_getLogs block hash mismatch. want=%.4x got=%.4x"
+			return fmt.Errorf(tag, blockHash, lresp.Result[i].BlockHash)
+		}
 		if logs, ok := logsByTx[k]; ok {
 			logsByTx[k] = append(logs, lresp.Result[i])
 			continue
@@ -811,7 +4797,19 @@ func (c *Client) logs(ctx context.Context, url string, filter *glf.Filter, bm bl
 		logsByTx[k] = []logResult{lresp.Result[i]}
 	}
 
-	for k, logs := range logsByTx {
+	// Txs are added to their blocks in (block, tx index) order
+	keys := make([]key, 0, len(logsByTx))
+	for k := range logsByTx {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(x, y key) int {
+		if x.a != y.a {
+			return cmp.Compare(x.a, y.a)
+		}
+		return cmp.Compare(x.b, y.b)
+	})
+	for _, k := range keys {
+		logs := logsByTx[k]
 		b, ok := bm[k.a]
 		if !ok {
 			return fmt.Errorf("block not found")
@@ -825,73 +4823,249 @@ func (c *Client) logs(ctx context.Context, url string, filter *glf.Filter, bm bl
 		}
 		b.Unlock()
 	}
-	slog.DebugContext(ctx, "http-get-logs",
-		"nlogs", len(lresp.Result),
-		"elapsed", time.Since(t0),
-	)
 	return nil
 }
 
+const (
+	missingHeaderRetries = 2
+	missingHeaderDelay   = 100 * time.Millisecond
+)
+
+// Requests the logs for lf. When header is true, the header
+// for the end of the range is requested in the same batch to
+// check that the node has all of the blocks in the range.
+// A null header is usually a momentary node race, so the
+// batch is retried before returning errMissingLogs.
+func (c *Client) getLogs(ctx context.Context, url string, start, limit uint64, lf logFilter, header bool) ([]logResult, error) {
+	for i := 0; ; i++ {
+		res, err := c.getLogsOnce(ctx, url, start, limit, lf, header)
+		if !errors.Is(err, errMissingLogs) || i == missingHeaderRetries {
+			return res, err
+		}
+		c.logger().DebugContext(ctx, "retrying logs", "attempt", i+1, "error", err)
+		t := c.clock.NewTicker(missingHeaderDelay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C():
+		}
+		t.Stop()
+	}
+}
+
+func (c *Client) getLogsOnce(ctx context.Context, url string, start, limit uint64, lf logFilter, header bool) ([]logResult, error) {
+	var (
+		toBlock = start + limit - 1
+		hresp   = &headerResp{}
+		lresp   = &logResp{}
+		reqs    []request
+		resp    []any
+	)
+	if header {
+		reqs = append(reqs, request{
+			ID:      c.id("eth_getBlockByNumber", fmt.Sprintf("blocks-%d-%d-%x", start, limit, randbytes())),
+			Version: c.version,
+			Method:  "eth_getBlockByNumber",
+			Params:  []any{eth.EncodeUint64(toBlock), false},
+		})
+		resp = append(resp, hresp)
+	}
+	reqs = append(reqs, request{
+		ID:      c.id("eth_getLogs", fmt.Sprintf("logs-%d-%d-%x", start, limit, randbytes())),
+		Version: c.version,
+		Method:  "eth_getLogs",
+		Params:  []any{lf},
+	})
+	resp = append(resp, lresp)
+	err := c.do(ctx, url, &resp, reqs)
+	if err != nil {
+		return nil, fmt.Errorf("making logs request: %w", err)
+	}
+	switch {
+	case hresp.Error.Exists():
+		return nil, fmt.Errorf("rpc=eth_getLogs/eth_getBlockByNumber %w", hresp.Error)
+	case lresp.Error.Exists():
+		return nil, fmt.Errorf("rpc=eth_getLogs %w", lresp.Error)
+	case header && hresp.Header == nil:
+		return nil, fmt.Errorf("%w for block: %d", errMissingLogs, toBlock)
+	}
+	return lresp.Result, nil
+}
+
 type traceBlockResult struct {
 	BlockHash eth.Bytes       `json:"blockHash"`
 	BlockNum  uint64          `json:"blockNumber"`
 	TxHash    eth.Bytes       `json:"transactionHash"`
 	TxIdx     uint64          `json:"transactionPosition"`
 	Action    eth.TraceAction `json:"action"`
+	traceOutcome
+}
+
+// The result and error keys of a trace
+type traceOutcome struct {
+	Result *struct {
+		GasUsed eth.Uint64 `json:"gasUsed"`
+		Output  eth.Bytes  `json:"output"`
+	} `json:"result"`
+	Error        string `json:"error"`
+	RevertReason string `json:"revertReason"`
+}
+
+// Returns ta with the outcome's fields. The revert reason
+// is decoded from the output when the node doesn't
+// provide it.
+func (to *traceOutcome) apply(ta eth.TraceAction) eth.TraceAction {
+	if to.Result != nil {
+		ta.GasUsed = to.Result.GasUsed
+		ta.Output = to.Result.Output
+	}
+	ta.Error = to.Error
+	ta.RevertReason = to.RevertReason
+	if ta.RevertReason == "" && ta.Error != "" && to.Result != nil {
+		ta.RevertReason = revertReason(to.Result.Output)
+	}
+	return ta
+}
+
+// Decodes the message from Error(string) revert data.
+// Returns an empty string for other data.
+func revertReason(b []byte) string {
+	selector := []byte{0x08, 0xc3, 0x79, 0xa0}
+	if len(b) < 4+64 || !bytes.Equal(b[:4], selector) {
+		return ""
+	}
+	b = b[4:]
+	off := new(uint256.Int).SetBytes(b[:32])
+	if !off.IsUint64() || off.Uint64() > uint64(len(b)-32) {
+		return ""
+	}
+	n := new(uint256.Int).SetBytes(b[off.Uint64() : off.Uint64()+32])
+	data := b[off.Uint64()+32:]
+	if !n.IsUint64() || n.Uint64() > uint64(len(data)) {
+		return ""
+	}
+	return string(data[:n.Uint64()])
 }
 
 type traceBlockResp struct {
-	Error  `json:"error"`
+	Error  Error              `json:"error"`
 	Result []traceBlockResult `json:"result"`
 }
 
-func (c *Client) traces(ctx context.Context, url string, bm blockmap, start, limit uint64) error {
+// Returns the number of blocks, starting from start,
+// that were traced before an error was encountered.
+func (c *Client) traces(ctx context.Context, url string, bm blockmap, start, limit uint64) (uint64, error) {
 	t0 := time.Now()
 	for i := uint64(0); i < limit; i++ {
-		res := traceBlockResp{}
-		req := request{
-			ID:      fmt.Sprintf("traces-%d-%d-%x", start, limit, randbytes()),
-			Version: "2.0",
-			Method:  "trace_block",
-			Params:  []any{eth.EncodeUint64(start + i)},
+		if err := ctx.Err(); err != nil {
+			return i, err
 		}
-		err := c.do(ctx, url, &res, req)
-		if err != nil {
-			return fmt.Errorf("requesting traces: %w", err)
+		trace := c.traceBlock
+		if c.traceMethod == "trace_replayBlockTransactions" {
+			trace = c.replayBlock
 		}
-		if res.Error.Exists() {
-			const tag = "trace_block"
-			return fmt.Errorf("rpc=%s %w", tag, res.Error)
+		if err := trace(ctx, url, bm, start, limit, start+i); err != nil {
+			return i, err
 		}
-		if len(res.Result) == 0 {
-			return fmt.Errorf("no rpc error but empty result")
+	}
+	c.logger().DebugContext(ctx, "http-get-traces", "elapsed", time.Since(t0))
+	return limit, nil
+}
+
+func (c *Client) traceBlock(ctx context.Context, url string, bm blockmap, start, limit, n uint64) error {
+	res := traceBlockResp{}
+	req := request{
+		ID:      c.id("trace_block", fmt.Sprintf("traces-%d-%d-%x", start, limit, randbytes())),
+		Version: c.version,
+		Method:  "trace_block",
+		Params:  []any{eth.EncodeUint64(n)},
+	}
+	err := c.do(ctx, url, &res, req)
+	if err != nil {
+		return fmt.Errorf("requesting traces: %w", err)
+	}
+	if res.Error.Exists() {
+		const tag = "trace_block"
+		return fmt.Errorf("rpc=%s %w", tag, res.Error)
+	}
+	if len(res.Result) == 0 {
+		return fmt.Errorf("no rpc error but empty result")
+	}
+	block, ok := bm[res.Result[0].BlockNum]
+	if !ok {
+		return fmt.Errorf("missing block in block map")
+	}
+	block.Header.Hash.Write(res.Result[0].BlockHash)
+
+	var tracesByTx = map[key][]traceBlockResult{}
+	for i := range res.Result {
+		k := key{block.Num(), uint64(res.Result[i].TxIdx)}
+		if traces, ok := tracesByTx[k]; ok {
+			tracesByTx[k] = append(traces, res.Result[i])
+			continue
 		}
-		block, ok := bm[res.Result[0].BlockNum]
-		if !ok {
-			return fmt.Errorf("missing block in block map")
+		tracesByTx[k] = []traceBlockResult{res.Result[i]}
+	}
+	for k, traces := range tracesByTx {
+		tx := block.Tx(k.b)
+		tx.PrecompHash.Write(traces[0].TxHash)
+		tx.TraceActions = make([]eth.TraceAction, len(traces))
+		for i := range traces {
+			ta := traces[i].apply(traces[i].Action)
+			ta.Idx = uint64(i)
+			tx.TraceActions[i] = ta
 		}
-		block.Header.Hash.Write(res.Result[0].BlockHash)
+	}
+	return nil
+}
 
-		var tracesByTx = map[key][]traceBlockResult{}
-		for i := range res.Result {
-			k := key{block.Num(), uint64(res.Result[i].TxIdx)}
-			if traces, ok := tracesByTx[k]; ok {
-				tracesByTx[k] = append(traces, res.Result[i])
-				continue
-			}
-			tracesByTx[k] = []traceBlockResult{res.Result[i]}
-		}
-		for k, traces := range tracesByTx {
-			tx := block.Tx(k.b)
-			tx.PrecompHash.Write(traces[0].TxHash)
-			tx.TraceActions = make([]eth.TraceAction, len(traces))
-			for i := range traces {
-				ta := traces[i].Action
-				ta.Idx = uint64(i)
-				tx.TraceActions[i] = ta
-			}
+type replayResult struct {
+	TxHash eth.Bytes `json:"transactionHash"`
+	Trace  []struct {
+		Action eth.TraceAction `json:"action"`
+		traceOutcome
+	} `json:"trace"`
+}
+
+type replayResp struct {
+	Error  Error          `json:"error"`
+	Result []replayResult `json:"result"`
+}
+
+// Like traceBlock but uses trace_replayBlockTransactions
+// for nodes that have trace_block disabled. The response
+// doesn't include tx positions so they are inferred
+// from the order of the results.
+func (c *Client) replayBlock(ctx context.Context, url string, bm blockmap, start, limit, n uint64) error {
+	res := replayResp{}
+	req := request{
+		ID:      c.id("trace_replayBlockTransactions", fmt.Sprintf("replay-%d-%d-%x", start, limit, randbytes())),
+		Version: c.version,
+		Method:  "trace_replayBlockTransactions",
+		Params:  []any{eth.EncodeUint64(n), []string{"trace"}},
+	}
+	err := c.do(ctx, url, &res, req)
+	if err != nil {
+		return fmt.Errorf("requesting replay traces: %w", err)
+	}
+	if res.Error.Exists() {
+		const tag = "trace_replayBlockTransactions"
+		return fmt.Errorf("rpc=%s %w", tag, res.Error)
+	}
+	block, ok := bm[n]
+	if !ok {
+		return fmt.Errorf("missing block in block map")
+	}
+	for i := range res.Result {
+		tx := block.Tx(uint64(i))
+		tx.PrecompHash.Write(res.Result[i].TxHash)
+		tx.TraceActions = make([]eth.TraceAction, len(res.Result[i].Trace))
+		for j := range res.Result[i].Trace {
+			ta := res.Result[i].Trace[j].apply(res.Result[i].Trace[j].Action)
+			ta.Idx = uint64(j)
+			tx.TraceActions[j] = ta
 		}
 	}
-	slog.DebugContext(ctx, "http-get-traces", "elapsed", time.Since(t0))
 	return nil
 }
@@ -16,7 +16,7 @@ type Filter struct {
 }
 
 func New(needs, addresses []string, topics [][]string) *Filter {
-	f := &Filter{}
+	f := &Filter{needs: append([]string{}, needs...)}
 	if any(needs, difference(receipt, block, log)) {
 		f.UseReceipts = true
 		needs = difference(needs, receipt)
@@ -42,6 +42,15 @@ func New(needs, addresses []string, topics [][]string) *Filter {
 	return f
 }
 
+// Reports whether any of fields were passed to New.
+// Filters that weren't created by New need every field.
+func (f *Filter) Needs(fields ...string) bool {
+	if f.needs == nil {
+		return true
+	}
+	return any(f.needs, fields)
+}
+
 func (f *Filter) Addresses() []string { return f.addresses }
 func (f *Filter) Topics() [][]string  { return f.topics }
 
@@ -61,3 +61,11 @@ func TestNeeds(t *testing.T) {
 		diff.Test(t, t.Errorf, f.UseLogs, tc.logs)
 	}
 }
+
+func TestFilter_Needs(t *testing.T) {
+	f := New([]string{"tx_status", "log_addr"}, nil, nil)
+	diff.Test(t, t.Errorf, true, f.Needs("log_addr", "log_idx"))
+	diff.Test(t, t.Errorf, false, f.Needs("tx_l1_fee"))
+	diff.Test(t, t.Errorf, false, New(nil, nil, nil).Needs("tx_status"))
+	diff.Test(t, t.Errorf, true, (&Filter{UseReceipts: true}).Needs("tx_l1_fee"))
+}
@@ -3,6 +3,7 @@ package wctx
 
 import (
 	"context"
+	"sync"
 	"sync/atomic"
 )
 
@@ -16,6 +17,7 @@ const (
 	counterKey  key = 5
 	numLimitKey key = 6
 	srcHostKey  key = 7
+	methodsKey  key = 8
 )
 
 func WithChainID(ctx context.Context, id uint64) context.Context {
@@ -74,6 +76,44 @@ func Counter(ctx context.Context) uint64 {
 	return *cptr
 }
 
+// Counts JSON-RPC calls by method
+type MethodCounter struct {
+	sync.Mutex
+	counts map[string]uint64
+}
+
+func WithMethodCounter(ctx context.Context, mc *MethodCounter) context.Context {
+	return context.WithValue(ctx, methodsKey, mc)
+}
+
+func MethodCounterAdd(ctx context.Context, method string, n uint64) {
+	mc, ok := ctx.Value(methodsKey).(*MethodCounter)
+	if !ok {
+		return
+	}
+	mc.Lock()
+	defer mc.Unlock()
+	if mc.counts == nil {
+		mc.counts = make(map[string]uint64)
+	}
+	mc.counts[method] += n
+}
+
+// Returns a copy of the counts
+func MethodCounts(ctx context.Context) map[string]uint64 {
+	mc, ok := ctx.Value(methodsKey).(*MethodCounter)
+	if !ok {
+		return nil
+	}
+	mc.Lock()
+	defer mc.Unlock()
+	res := make(map[string]uint64, len(mc.counts))
+	for m, n := range mc.counts {
+		res[m] = n
+	}
+	return res
+}
+
 type numLimit struct{ num, limit uint64 }
 
 func WithNumLimit(ctx context.Context, n, l uint64) context.Context {
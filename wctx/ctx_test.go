@@ -15,3 +15,15 @@ func TestCounter(t *testing.T) {
 	CounterAdd(ctx, 1)
 	tc.WantGot(t, uint64(3), Counter(ctx))
 }
+
+func TestMethodCounter(t *testing.T) {
+	tc.WantGot(t, map[string]uint64(nil), MethodCounts(context.Background()))
+	MethodCounterAdd(context.Background(), "eth_getLogs", 1)
+
+	ctx := WithMethodCounter(context.Background(), &MethodCounter{})
+	MethodCounterAdd(ctx, "eth_getLogs", 1)
+	MethodCounterAdd(ctx, "eth_getBlockByNumber", 2)
+	MethodCounterAdd(ctx, "eth_getLogs", 1)
+	want := map[string]uint64{"eth_getLogs": 2, "eth_getBlockByNumber": 2}
+	tc.WantGot(t, want, MethodCounts(ctx))
+}